@@ -0,0 +1,103 @@
+package plugin
+
+import "strings"
+
+var InvalidArgError = Xrror("invalid plugin argument %q: %s").Out
+
+// shellMetachars are the characters a POSIX shell would interpret.
+const shellMetachars = " \t\n'\"\\`$;&|<>(){}[]*?!~#"
+
+// ArgsBuilder builds the arguments a plugin is started with, checking
+// each as it is added. The first invalid argument is reported by Build, or
+// by NewClient when the builder is used through Option.
+type ArgsBuilder struct {
+	args  []string
+	shell bool
+	err   error
+}
+
+// NewArgs returns a builder starting with args.
+func NewArgs(args ...string) *ArgsBuilder {
+	return new(ArgsBuilder).Arg(args...)
+}
+
+// Shell makes the builder reject arguments containing shell
+// metacharacters, for plugins run through a launcher that passes its
+// arguments to a shell. It applies to arguments already added as well.
+func (b *ArgsBuilder) Shell() *ArgsBuilder {
+	b.shell = true
+	for _, arg := range b.args {
+		b.check(arg)
+	}
+	return b
+}
+
+// Arg adds args as they are.
+func (b *ArgsBuilder) Arg(args ...string) *ArgsBuilder {
+	for _, arg := range args {
+		b.check(arg)
+		b.args = append(b.args, arg)
+	}
+	return b
+}
+
+// WithFlag adds the flag --name=value.
+func (b *ArgsBuilder) WithFlag(name, value string) *ArgsBuilder {
+	b.checkFlag(name)
+	return b.Arg("--" + name + "=" + value)
+}
+
+// Flag adds the boolean flag --name.
+func (b *ArgsBuilder) Flag(name string) *ArgsBuilder {
+	b.checkFlag(name)
+	return b.Arg("--" + name)
+}
+
+// Build returns the arguments, or the first invalid one as an error.
+func (b *ArgsBuilder) Build() ([]string, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return append([]string(nil), b.args...), nil
+}
+
+// Option returns the arguments as an Option, like WithArgs.
+func (b *ArgsBuilder) Option() Option {
+	args, err := b.Build()
+	return func(c *config) {
+		c.args, c.argsErr = args, err
+	}
+}
+
+func (b *ArgsBuilder) checkFlag(name string) {
+	switch {
+	case name == "":
+		b.fail(name, "empty flag name")
+	case strings.HasPrefix(name, "-"):
+		b.fail(name, "flag name starts with -")
+	case strings.Contains(name, "="):
+		b.fail(name, "flag name contains =")
+	}
+}
+
+func (b *ArgsBuilder) check(arg string) {
+	if err := checkArg(arg, b.shell); err != nil && b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *ArgsBuilder) fail(arg, reason string) {
+	if b.err == nil {
+		b.err = InvalidArgError(arg, reason)
+	}
+}
+
+func checkArg(arg string, shell bool) error {
+	if strings.IndexByte(arg, 0) >= 0 {
+		return InvalidArgError(arg, "contains a null byte")
+	}
+	if shell && strings.ContainsAny(arg, shellMetachars) {
+		return InvalidArgError(arg, "contains shell metacharacters")
+	}
+	return nil
+}
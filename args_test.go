@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArgsBuilder(t *testing.T) {
+	args, err := NewArgs("serve").WithFlag("port", "8080").Flag("verbose").Build()
+	if err != nil || strings.Join(args, " ") != "serve --port=8080 --verbose" {
+		t.Errorf("unexpected args %q, %v", args, err)
+	}
+
+	for _, b := range []*ArgsBuilder{
+		NewArgs("bad\x00arg"),
+		NewArgs().WithFlag("", "x"),
+		NewArgs().WithFlag("-double", "x"),
+		NewArgs("ok").WithFlag("name", "$(rm -rf /)").Shell(),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("expected %q to be rejected", b.args)
+		}
+	}
+	if _, err := NewArgs().WithFlag("name", "$(rm -rf /)").Build(); err != nil {
+		t.Errorf("expected metacharacters to be allowed without Shell, got %v", err)
+	}
+}
+
+func TestWithArgsValidation(t *testing.T) {
+	if _, err := NewClient("plugin", WithArgs("a\x00b")); err == nil {
+		t.Error("expected a null byte in WithArgs to fail the launch")
+	}
+	if _, err := NewClient("plugin", NewArgs().WithFlag("", "x").Option()); err == nil {
+		t.Error("expected an invalid builder to fail the launch")
+	}
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, NewArgs(args...).Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
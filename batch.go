@@ -0,0 +1,42 @@
+package plugin
+
+import "sync"
+
+// Call is one call in a batch passed to Client.CallBatch.
+type Call struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+}
+
+// BatchConcurrency limits CallBatch to n calls in flight at once. By
+// default every call in a batch is sent at once.
+func BatchConcurrency(n int) Option {
+	return func(c *config) {
+		c.batchConcurrency = n
+	}
+}
+
+// CallBatch makes all calls concurrently, pipelined over the one connection
+// to the plugin, and returns once they have all finished. The error for
+// calls[i] is at index i of the result.
+func (c *Client) CallBatch(calls []Call) []error {
+	errs := make([]error, len(calls))
+	limit := c.conf.batchConcurrency
+	if limit <= 0 || limit > len(calls) {
+		limit = len(calls)
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, call Call) {
+			defer wg.Done()
+			errs[i] = c.Call(call.ServiceMethod, call.Args, call.Reply)
+			<-sem
+		}(i, call)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCallBatch(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	c, err := NewClient("test", BatchConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	replies := make([]string, 5)
+	calls := make([]Call, len(replies))
+	for i := range calls {
+		calls[i] = Call{"Test.Echo", fmt.Sprint(i), &replies[i]}
+	}
+	calls[3].ServiceMethod = "Test.Missing"
+	for i, err := range c.CallBatch(calls) {
+		if i == 3 {
+			if !errors.As(err, new(*ProtocolError)) {
+				t.Errorf("expected a ProtocolError for the unknown method, got %v", err)
+			}
+			continue
+		}
+		if err != nil || replies[i] != fmt.Sprint(i) {
+			t.Errorf("call %d: unexpected result %q, %v", i, replies[i], err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"net"
+	"net/rpc"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// BenchResult holds the call latencies Benchmark measured, and its
+// throughput in calls per second.
+type BenchResult struct {
+	Min, Max, Mean, P95, P99 time.Duration
+	Throughput               float64
+}
+
+var BenchIterationsError = Xrror("benchmark needs at least one iteration, got %d").Out
+
+// Benchmark calls method, given as "Service.Method", iterations times with
+// zero value arguments and measures each call. Calls go over an in-memory
+// connection through the plugin's codec, middleware and rate limits, so the
+// result is the overhead of a call less the host's transport. Errors
+// returned by the method itself are counted as calls like any other.
+func (p *Plugin) Benchmark(method string, iterations int) (BenchResult, error) {
+	if iterations < 1 {
+		return BenchResult{}, BenchIterationsError(iterations)
+	}
+	_, mt, err := p.dispatch.lookup(method)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	host, conn := net.Pipe()
+	go p.dispatch.serveCodec(newGobServerCodecSize(conn, p.conf.bufferSize, p.conf.messageLimit()))
+	client := newClient(host, p.conf.messageLimit())
+	defer client.Close()
+
+	argType := mt.argType
+	if argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	latencies := make([]time.Duration, iterations)
+	var total time.Duration
+	for i := range latencies {
+		args, reply := reflect.New(argType).Interface(), reflect.New(mt.replyType.Elem()).Interface()
+		start := time.Now()
+		err := client.Call(method, args, reply)
+		latencies[i] = time.Since(start)
+		total += latencies[i]
+		if _, ok := err.(rpc.ServerError); err != nil && !ok {
+			return BenchResult{}, err
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return BenchResult{
+		Min:        latencies[0],
+		Max:        latencies[iterations-1],
+		Mean:       total / time.Duration(iterations),
+		P95:        percentile(latencies, 95),
+		P99:        percentile(latencies, 99),
+		Throughput: float64(iterations) / total.Seconds(),
+	}, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted.
+func percentile(sorted []time.Duration, pct int) time.Duration {
+	rank := (len(sorted)*pct + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBenchmark(t *testing.T) {
+	p, _ := pipePlugin(t, newTestAPI())
+	r, err := p.Benchmark("Test.Echo", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Mean <= 0 || r.Max < r.Min || r.P95 < r.Min || r.P99 < r.P95 || r.Max < r.P99 || r.Throughput <= 0 {
+		t.Errorf("inconsistent result %+v", r)
+	}
+	if _, err := p.Benchmark("Test.Missing", 1); err == nil {
+		t.Error("expected an error benchmarking an unknown method")
+	}
+	if _, err := p.Benchmark("Test.Echo", 0); err == nil {
+		t.Error("expected an error for no iterations")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i + 1)
+	}
+	if p95, p99 := percentile(sorted, 95), percentile(sorted, 99); p95 != 95 || p99 != 99 {
+		t.Errorf("expected 95 and 99, got %d and %d", p95, p99)
+	}
+	if p := percentile(sorted[:1], 95); p != 1 {
+		t.Errorf("expected the only sample, got %d", p)
+	}
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+)
+
+// Streams of a two-way connection, named for the direction of the calls
+// they carry.
+const (
+	hostCallStream   byte = 0
+	pluginCallStream byte = 1
+)
+
+// BiDiPlugin is one end of a two-way RPC connection between a host and a
+// plugin, multiplexed over the plugin's stdio with a Framer. The embedded
+// Client calls the other end, while a goroutine serves this end's service.
+type BiDiPlugin struct {
+	*rpc.Client
+	server  *Plugin
+	framer  *Framer
+	streams *streamer
+	done    chan struct{}
+}
+
+// NewBiDi starts the plugin at path for two-way calls: the host calls the
+// plugin through the returned BiDiPlugin, and the plugin calls back into
+// callback, which is registered as the service name. The plugin must be
+// created with NewBiDiPlugin. Callbacks are limited by
+// WithCallbackRateLimit, not WithRateLimit.
+func NewBiDi(name, path string, callback interface{}, opts ...Option) (*BiDiPlugin, error) {
+	conf := newConfig(opts)
+	cmd, err := conf.command(path)
+	if err != nil {
+		return nil, err
+	}
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := hostHandshake(pipe, conf)
+	if err != nil {
+		return nil, err
+	}
+	return newBiDi(conn, true, newPlugin(name, "", nil, callback, opts)), nil
+}
+
+// NewBiDiPlugin is New for a plugin started with NewBiDi. It serves api as
+// the service name straight away; calls made through the embedded Client
+// reach the host's callback service. The plugin should block on Done.
+func NewBiDiPlugin(name, path string, api interface{}, opts ...Option) (*BiDiPlugin, error) {
+	p := New(name, path, api, opts...)
+	conn, err := p.transport()
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return newBiDi(conn, false, p), nil
+}
+
+// newBiDi frames conn, whose handshake is done, and serves server on this
+// end's stream.
+func newBiDi(conn io.ReadWriteCloser, host bool, server *Plugin) *BiDiPlugin {
+	serve, call := hostCallStream, pluginCallStream
+	serveStreams, callStreams := hostStreamStream, pluginStreamStream
+	if host {
+		serve, call = call, serve
+		serveStreams, callStreams = callStreams, serveStreams
+		server.dispatch.limiter = server.conf.callbackRateLimiter()
+	}
+	framer := NewFramer(conn, serve, call, serveStreams, callStreams)
+	server.ReadWriteCloser = framer.Stream(serve)
+	server.handshake = false
+	b := &BiDiPlugin{
+		Client:  newClient(framer.Stream(call), server.conf.messageLimit()),
+		server:  server,
+		framer:  framer,
+		streams: newStreamer(framer.Stream(callStreams), framer.Stream(serveStreams)),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		server.Serve()
+		close(b.done)
+	}()
+	return b
+}
+
+// Done is closed once the other end has hung up and this end's calls in
+// progress have returned.
+func (b *BiDiPlugin) Done() <-chan struct{} {
+	return b.done
+}
+
+// Close ends both directions and closes the connection, which for the host
+// stops the plugin process.
+func (b *BiDiPlugin) Close() error {
+	b.Client.Close()
+	return b.framer.Close()
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type hostAPI struct {
+	host *BiDiPlugin
+}
+
+// Ask has the host call back into the plugin while answering the plugin.
+func (h *hostAPI) Ask(args string, reply *string) error {
+	return h.host.Call("Test.Echo", "host heard "+args, reply)
+}
+
+func TestBiDi(t *testing.T) {
+	hostConn, pluginConn := net.Pipe()
+	plugin := newBiDi(pluginConn, false, newPlugin("Test", "", nil, newTestAPI(), nil))
+	api := &hostAPI{}
+	host := newBiDi(hostConn, true, newPlugin("Host", "", nil, api, nil))
+	api.host = host
+	defer host.Close()
+
+	var reply string
+	if err := host.Call("Test.Echo", "to plugin", &reply); err != nil || reply != "to plugin" {
+		t.Errorf("host to plugin: unexpected result %q, %v", reply, err)
+	}
+	if err := plugin.Call("Host.Ask", "to host", &reply); err != nil || reply != "host heard to host" {
+		t.Errorf("plugin to host: unexpected result %q, %v", reply, err)
+	}
+
+	host.Close()
+	select {
+	case <-plugin.Done():
+	case <-time.After(time.Second):
+		t.Error("expected the plugin to stop serving once the host closed")
+	}
+}
+
+func TestNewBiDi(t *testing.T) {
+	path, args := helperProcess(t, "bidi")
+	host, err := NewBiDi("Host", path, &hostAPI{}, WithArgs(args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	var reply string
+	if err := host.Call("Test.Echo", "over stdio", &reply); err != nil || reply != "over stdio" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestCallbackRateLimit(t *testing.T) {
+	hostConn, pluginConn := net.Pipe()
+	plugin := newBiDi(pluginConn, false, newPlugin("Test", "", nil, newTestAPI(), nil))
+	host := newBiDi(hostConn, true, newPlugin("Host", "", nil, newTestAPI(), []Option{WithCallbackRateLimit(10, 3)}))
+	defer host.Close()
+
+	var ok, limited int
+	for i := 0; i < 8; i++ {
+		var reply string
+		switch err := plugin.Call("Host.Echo", "flood", &reply); {
+		case err == nil:
+			ok++
+		case errors.Is(classifyError(err), RateLimitedError):
+			limited++
+		default:
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if ok != 3 || limited != 5 {
+		t.Errorf("expected a burst of 3 callbacks through and 5 rejected, got %d and %d", ok, limited)
+	}
+	var reply string
+	if err := host.Call("Test.Echo", "unlimited", &reply); err != nil {
+		t.Errorf("expected host calls to be unaffected, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+type caller interface {
+	Call(serviceMethod string, args, reply interface{}) error
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+var ErrCircuitOpen = Xrror("circuit breaker is open, plugin not called")
+
+// CircuitBreaker fails calls fast with ErrCircuitOpen once threshold
+// consecutive calls have failed, until openDuration has passed and a single
+// probe call is let through. Errors returned by the plugin's own methods
+// (rpc.ServerError) show the plugin is alive and do not count as failures.
+type CircuitBreaker struct {
+	client       caller
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(client *rpc.Client, threshold int, openDuration time.Duration) *CircuitBreaker {
+	return newCircuitBreaker(client, threshold, openDuration)
+}
+
+func newCircuitBreaker(client caller, threshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{client: client, threshold: threshold, openDuration: openDuration}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current()
+}
+
+func (b *CircuitBreaker) current() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openDuration {
+		b.state = BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) Call(serviceMethod string, args, reply interface{}) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.client.Call(serviceMethod, args, reply)
+	b.record(err)
+	return err
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.current() {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if _, ok := err.(rpc.ServerError); err == nil || ok {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type fakeCaller struct {
+	calls int
+	err   error
+}
+
+func (f *fakeCaller) Call(string, interface{}, interface{}) error {
+	f.calls++
+	return f.err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	fake := &fakeCaller{err: io.ErrUnexpectedEOF}
+	b := newCircuitBreaker(fake, 3, 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Call("Test.Echo", nil, nil); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected plugin error, got %v", err)
+		}
+	}
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("expected open after threshold failures, got %d", s)
+	}
+	if err := b.Call("Test.Echo", nil, nil); err != ErrCircuitOpen || fake.calls != 3 {
+		t.Fatalf("expected ErrCircuitOpen without calling plugin, got %v after %d calls", err, fake.calls)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("expected half-open after open duration, got %d", s)
+	}
+	b.Call("Test.Echo", nil, nil)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("expected failed probe to reopen, got %d", s)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	fake.err = nil
+	if err := b.Call("Test.Echo", nil, nil); err != nil {
+		t.Fatalf("unexpected probe error: %s", err)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("expected successful probe to close, got %d", s)
+	}
+
+	fake.err = rpc.ServerError("application error")
+	for i := 0; i < 5; i++ {
+		b.Call("Test.Echo", nil, nil)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Errorf("application errors should not open the breaker, got %d", s)
+	}
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import "sync"
+
+// busService is the reserved service through which a PluginBus delivers
+// messages to plugins.
+const busService = "__Bus"
+
+// BusMessage is a message published on a PluginBus. Payload travels as an
+// interface value, so with the gob codec its concrete type must be
+// registered with gob.Register in both host and plugin, unless it is one of
+// Go's basic types.
+type BusMessage struct {
+	Topic   string
+	Payload interface{}
+}
+
+// PluginBus publishes messages from the host to every plugin added to it,
+// over each plugin's own RPC connection. Plugins receive the topics they
+// Subscribe to and ignore the rest.
+type PluginBus struct {
+	mu      sync.Mutex
+	clients []*Client
+}
+
+func NewPluginBus(clients ...*Client) *PluginBus {
+	return &PluginBus{clients: clients}
+}
+
+func (b *PluginBus) Add(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients = append(b.clients, c)
+}
+
+// Publish delivers payload under topic to every plugin on the bus at once,
+// and returns once they have all run their handlers. A plugin that fails to
+// take the message does not stop the others getting it; the first such
+// error is returned.
+func (b *PluginBus) Publish(topic string, payload interface{}) error {
+	b.mu.Lock()
+	clients := append([]*Client(nil), b.clients...)
+	b.mu.Unlock()
+	msg := BusMessage{Topic: topic, Payload: payload}
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			errs[i] = c.Call(busService+".Publish", msg, new(struct{}))
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe has handler called with the payload of each message published
+// under topic on a PluginBus the plugin's host added it to.
+func (p *Plugin) Subscribe(topic string, handler func(interface{})) {
+	p.bus.add(topic, handler)
+}
+
+// subscriptions serves busService.
+type subscriptions struct {
+	mu       sync.RWMutex
+	handlers map[string][]func(interface{})
+}
+
+func (s *subscriptions) add(topic string, handler func(interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string][]func(interface{}))
+	}
+	s.handlers[topic] = append(s.handlers[topic], handler)
+}
+
+func (s *subscriptions) Publish(msg BusMessage, reply *struct{}) error {
+	s.mu.RLock()
+	handlers := s.handlers[msg.Topic]
+	s.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(msg.Payload)
+	}
+	return nil
+}
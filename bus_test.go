@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPluginBus(t *testing.T) {
+	bus := NewPluginBus()
+	received := make([]chan interface{}, 2)
+	for i := range received {
+		host, conn := net.Pipe()
+		p := NewFromConn("Test", conn, newTestAPI())
+		ch := make(chan interface{}, 1)
+		received[i] = ch
+		p.Subscribe("config", func(payload interface{}) { ch <- payload })
+		go p.Serve()
+		c := NewClientFromConn(host)
+		defer c.Close()
+		bus.Add(c)
+	}
+
+	if err := bus.Publish("config", "reload"); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range received {
+		select {
+		case payload := <-ch:
+			if payload != "reload" {
+				t.Errorf("subscriber %d: expected %q, got %v", i, "reload", payload)
+			}
+		default:
+			t.Errorf("subscriber %d got nothing", i)
+		}
+	}
+
+	if err := bus.Publish("other", 1); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range received {
+		if len(ch) != 0 {
+			t.Errorf("subscriber %d got a message for a topic it did not subscribe to", i)
+		}
+	}
+}
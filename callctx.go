@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"reflect"
+)
+
+// contextService is the reserved service that unwraps calls made with
+// SendContext.
+const contextService = "__Context"
+
+// CallContext carries metadata, such as trace IDs or auth tokens, with a
+// single call made by Client.SendContext. A method receives it when its
+// args type embeds CallContext, and reads it with ContextValue.
+type CallContext struct {
+	Metadata map[string]string
+}
+
+// ContextValue returns the metadata sent with the call under key, or "".
+func (c *CallContext) ContextValue(key string) string {
+	return c.Metadata[key]
+}
+
+func (c *CallContext) callContext() *CallContext {
+	return c
+}
+
+type contextual interface {
+	callContext() *CallContext
+}
+
+// ContextRequest wraps a call made with SendContext. Args and the reply are
+// gob encoded whatever the connection's codec, so the wrapper carries any
+// method's types.
+type ContextRequest struct {
+	Method   string
+	Metadata map[string]string
+	Args     []byte
+}
+
+// SendContext is Call sending ctx's metadata along with the call.
+func (c *Client) SendContext(ctx CallContext, serviceMethod string, args, reply interface{}) error {
+	return c.sendContext(context.Background(), ctx.Metadata, serviceMethod, args, reply)
+}
+
+func (c *Client) sendContext(ctx context.Context, meta map[string]string, serviceMethod string, args, reply interface{}) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args); err != nil {
+		return err
+	}
+	req := ContextRequest{Method: serviceMethod, Metadata: meta, Args: buf.Bytes()}
+	var resp []byte
+	if err := c.CallCtx(ctx, contextService+".Call", req, &resp); err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(resp)).Decode(reply)
+}
+
+// contextUnwrapper serves contextService.
+type contextUnwrapper struct {
+	d         *dispatcher
+	startSpan func(traceparent, serviceMethod string) func(error)
+}
+
+func (u contextUnwrapper) Call(req ContextRequest, reply *[]byte) error {
+	s, mt, err := u.d.lookup(req.Method)
+	if err != nil {
+		return err
+	}
+	argType := mt.argType
+	if argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	argv := reflect.New(argType)
+	if err := gob.NewDecoder(bytes.NewReader(req.Args)).Decode(argv.Interface()); err != nil {
+		return err
+	}
+	if c, ok := argv.Interface().(contextual); ok {
+		c.callContext().Metadata = req.Metadata
+	}
+	if mt.argType.Kind() != reflect.Ptr {
+		argv = argv.Elem()
+	}
+	replyv := reflect.New(mt.replyType.Elem())
+	handler := u.d.handler(s, mt)
+	if traceparent := req.Metadata[TraceParentKey]; u.startSpan != nil && validTraceParent(traceparent) {
+		end := u.startSpan(traceparent, req.Method)
+		err = handler(argv.Interface(), replyv.Interface())
+		end(err)
+	} else {
+		err = handler(argv.Interface(), replyv.Interface())
+	}
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(replyv.Interface()); err != nil {
+		return err
+	}
+	*reply = buf.Bytes()
+	return nil
+}
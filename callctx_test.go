@@ -0,0 +1,49 @@
+package plugin
+
+import "testing"
+
+type tracedArgs struct {
+	CallContext
+	Name string
+}
+
+type tracedAPI struct{}
+
+func (tracedAPI) Greet(args *tracedArgs, reply *string) error {
+	*reply = args.ContextValue("trace-id") + ": hello " + args.Name
+	return nil
+}
+
+func (tracedAPI) Parent(args *tracedArgs, reply *string) error {
+	*reply = args.ContextValue(TraceParentKey) + ": hello " + args.Name
+	return nil
+}
+
+func (tracedAPI) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func TestSendContext(t *testing.T) {
+	withFakePlugin(t, tracedAPI{})
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := CallContext{Metadata: map[string]string{"trace-id": "abc123"}}
+	var reply string
+	if err := c.SendContext(ctx, "Test.Greet", &tracedArgs{Name: "plugin"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if want := "abc123: hello plugin"; reply != want {
+		t.Errorf("expected %q, got %q", want, reply)
+	}
+	if err := c.SendContext(ctx, "Test.Echo", "plain", &reply); err != nil || reply != "plain" {
+		t.Errorf("expected methods without a CallContext to work, got %q, %v", reply, err)
+	}
+	if err := c.SendContext(ctx, "Test.Missing", "", &reply); err == nil {
+		t.Error("expected an error calling an unknown method")
+	}
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// ChainStage is one command of a CommandChain.
+type ChainStage struct {
+	Path string
+	Args []string
+}
+
+// CommandChain is a pipeline of processes, each reading the output of the
+// one before it like a shell pipeline. Writes go to the first stage and
+// reads come from the last, so a chain of plugins that forward RPC traffic
+// can be handed to rpc.NewClient like any other transport.
+type CommandChain struct {
+	in       io.WriteCloser
+	out      io.ReadCloser
+	procs    []osProcess
+	exits    []*exitStatus
+	once     sync.Once
+	closeErr error
+}
+
+var (
+	EmptyChainError        = Xrror("command chain has no stages")
+	ChainNotSupportedError = Xrror("command chain stages must be started as local processes")
+)
+
+// NewChain starts every stage of a chain, wiring each one's stdout to the
+// next one's stdin. If any stage fails to start, those already started are
+// killed and none is left running.
+func NewChain(stages []ChainStage) (*CommandChain, error) {
+	if len(stages) == 0 {
+		return nil, EmptyChainError
+	}
+	cmds := make([]execCmd, len(stages))
+	for i, s := range stages {
+		cmd, ok := makeCommand(nil, s.Path, s.Args).(execCmd)
+		if !ok {
+			return nil, ChainNotSupportedError
+		}
+		cmds[i] = cmd
+	}
+	c := &CommandChain{}
+	in, err := cmds[0].StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmds[len(cmds)-1].StdoutPipe()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	c.in, c.out = in, out
+	var links []*os.File
+	defer func() {
+		for _, f := range links {
+			f.Close()
+		}
+	}()
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			c.abort()
+			return nil, err
+		}
+		links = append(links, r, w)
+		cmds[i].Stdout, cmds[i+1].Stdin = w, r
+	}
+	for _, cmd := range cmds {
+		proc, err := cmd.Start()
+		if err != nil {
+			c.abort()
+			return nil, err
+		}
+		c.procs = append(c.procs, proc)
+		c.exits = append(c.exits, new(exitStatus))
+	}
+	return c, nil
+}
+
+func (c *CommandChain) Read(p []byte) (int, error) {
+	return c.out.Read(p)
+}
+
+func (c *CommandChain) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+// Close closes the first stage's stdin, then stops the stages in reverse
+// order, as ioPipe.Close stops a single plugin, and returns the first
+// error. Close may be called more than once and concurrently; each call
+// returns the first's result.
+func (c *CommandChain) Close() error {
+	c.once.Do(func() {
+		err := c.in.Close()
+		for i := len(c.procs) - 1; i >= 0; i-- {
+			if stopErr := stopProc(c.procs[i], c.exits[i], procTimeout, killWaitTimeout, nil); stopErr != nil && err == nil {
+				err = stopErr
+			}
+		}
+		c.out.Close()
+		c.closeErr = err
+	})
+	return c.closeErr
+}
+
+// abort kills the stages started so far.
+func (c *CommandChain) abort() {
+	for _, proc := range c.procs {
+		proc.Kill()
+		proc.Wait()
+	}
+	c.in.Close()
+	c.out.Close()
+}
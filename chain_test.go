@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"io"
+	"os/exec"
+	"testing"
+)
+
+func TestCommandChain(t *testing.T) {
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip(err)
+	}
+	chain, err := NewChain([]ChainStage{{Path: cat}, {Path: cat}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "through two stages\n"
+	if _, err := io.WriteString(chain, msg); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(msg))
+	if _, err := io.ReadFull(chain, b); err != nil || string(b) != msg {
+		t.Errorf("unexpected output %q, %v", b, err)
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- chain.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error closing the chain: %s", err)
+		}
+	}
+
+	if _, err := NewChain([]ChainStage{{Path: cat}, {Path: "/nonexistent/plugin"}}); err == nil {
+		t.Error("expected a missing stage to fail the whole chain")
+	}
+}
@@ -0,0 +1,450 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type Client struct {
+	path string
+	conf *config
+	dial func() (net.Conn, error)
+
+	mu       sync.Mutex
+	rpc      *rpc.Client
+	conn     io.ReadWriteCloser
+	pipe     *ioPipe
+	exit     *exitStatus
+	inflight int
+	lastCall time.Time
+	idle     *time.Timer
+	closed   bool
+	launched bool
+	manifest *Manifest
+	notify   func(EventType, error)
+	using    map[*rpc.Client]int
+	retired  map[*rpc.Client]chan struct{}
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+var (
+	ClientClosedError   = Xrror("plugin client is closed")
+	MethodTimeoutError  = Xrror("plugin call timed out")
+	ConnectionLostError = Xrror("plugin connection was closed and cannot be re-established")
+	CallCanceledError   = Xrror("plugin call to %s abandoned: %s").Out
+)
+
+func NewClient(path string, opts ...Option) (*Client, error) {
+	return NewClientContext(context.Background(), path, opts...)
+}
+
+// NewClientContext is NewClient with a context that cancels any launch
+// retries configured with WithStartRetry.
+func NewClientContext(ctx context.Context, path string, opts ...Option) (*Client, error) {
+	c := StartLazy(path, opts...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.launch(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// StartLazy returns a Client that does not launch the plugin process until
+// the first call is made on it. Concurrent first calls share one launch.
+func StartLazy(path string, opts ...Option) *Client {
+	return &Client{path: path, conf: newConfig(opts)}
+}
+
+// NewClientFromConn returns a Client that talks to a plugin over an
+// already established conn, such as one handed over by a connection broker.
+// Closing the client closes conn. There is no process to relaunch, so once
+// conn has been closed, by Close or IdleTimeout, calls fail with
+// ConnectionLostError.
+func NewClientFromConn(conn net.Conn, opts ...Option) *Client {
+	c := &Client{conf: newConfig(opts)}
+	c.dial = func() (net.Conn, error) {
+		if conn == nil {
+			return nil, ConnectionLostError
+		}
+		defer func() { conn = nil }()
+		return conn, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.launch(context.Background())
+	return c
+}
+
+// DialClient returns a Client that talks to a plugin serving on the network
+// address, such as one created with NewFromConn on an accepted connection.
+// The address is dialled again whenever the client relaunches.
+func DialClient(network, address string, opts ...Option) (*Client, error) {
+	c := &Client{conf: newConfig(opts)}
+	c.dial = func() (net.Conn, error) {
+		return net.Dial(network, address)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.launch(context.Background()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reconnect replaces the client's connection to the plugin, as recovery
+// after a transport failure. A plugin process is stopped and relaunched,
+// with a new handshake, and a DialClient dials its address again; a client
+// from NewClientFromConn has nothing to reconnect to and fails with
+// ConnectionLostError. Calls in progress on the old connection fail.
+func (c *Client) Reconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ClientClosedError
+	}
+	c.shutdown()
+	return c.launch(context.Background())
+}
+
+// Call calls serviceMethod on the plugin, relaunching it first if it was
+// shut down for idleness. Failures are reported as TransportError or
+// ProtocolError; errors from the plugin method itself pass through.
+func (c *Client) Call(serviceMethod string, args, reply interface{}) error {
+	return c.CallCtx(context.Background(), serviceMethod, args, reply)
+}
+
+// CallCtx is Call giving up when ctx is done, with CallCanceledError
+// wrapping ctx.Err(). A MethodTimeout for serviceMethod further bounds
+// ctx; when that deadline is what expires the plugin is taken to be stuck,
+// its connection is shut down and the call fails with MethodTimeoutError.
+// The reply is decoded into a copy and only stored into reply if the call
+// completes in time, so reply is never written after CallCtx returns.
+// net/rpc has no way to tell the plugin a call was abandoned: the plugin
+// may still be working on it, and its late reply is discarded.
+func (c *Client) CallCtx(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return CallCanceledError(serviceMethod, err)
+	}
+	client, manifest, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release(client)
+	parent := ctx
+	if timeout, ok := c.conf.methodTimeouts[serviceMethod]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if call := goCall(ctx, client, serviceMethod, args, reply); call != nil {
+		return classifyError(unsupported(manifest, serviceMethod, call.Error))
+	}
+	if parent.Err() == nil {
+		c.abandon(client)
+		return MethodTimeoutError
+	}
+	return CallCanceledError(serviceMethod, parent.Err())
+}
+
+// CallTimeout is Call failing with CallTimeoutError if the plugin has not
+// replied within timeout. Only the one call is abandoned; the connection
+// stays up for other calls.
+func (c *Client) CallTimeout(serviceMethod string, args, reply interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := c.CallCtx(ctx, serviceMethod, args, reply)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CallTimeoutError(serviceMethod, timeout)
+	}
+	return err
+}
+
+// goCall sends serviceMethod on client and waits for it or for ctx, giving
+// the completed call or nil if ctx was done first. The reply is decoded
+// into a copy, stored into reply only on completion, so an abandoned call
+// never writes reply; its late result lands in a buffered channel nobody
+// reads, and no goroutine is left waiting on it.
+func goCall(ctx context.Context, client *rpc.Client, serviceMethod string, args, reply interface{}) *rpc.Call {
+	replyv := reflect.ValueOf(reply)
+	into := reply
+	if replyv.Kind() == reflect.Ptr && !replyv.IsNil() {
+		into = reflect.New(replyv.Type().Elem()).Interface()
+	}
+	call := client.Go(serviceMethod, args, into, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil && into != reply {
+			replyv.Elem().Set(reflect.ValueOf(into).Elem())
+		}
+		return call
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ClientClosedError
+	}
+	c.closed = true
+	return c.shutdown()
+}
+
+func (c *Client) abandon(client *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rpc == client {
+		if c.pipe != nil {
+			// The plugin is stuck on the call, so there is no point
+			// waiting for it to finish writing.
+			c.pipe.skipDrain = true
+		}
+		c.shutdown()
+	}
+}
+
+// Stderr returns the standard error of the running plugin process when the
+// client was created with CaptureStderrPipe, and nil otherwise.
+func (c *Client) Stderr() io.Reader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pipe == nil {
+		return nil
+	}
+	return c.pipe.Stderr()
+}
+
+// Pid returns the process ID of the running plugin, or 0 if there is none
+// or it was not started as a local process.
+func (c *Client) Pid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pipe == nil || c.rpc == nil {
+		return 0
+	}
+	return c.pipe.pid
+}
+
+func (c *Client) setNotify(fn func(EventType, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = fn
+}
+
+func (c *Client) emit(typ EventType, err error) {
+	if c.notify != nil {
+		c.notify(typ, err)
+	}
+}
+
+func (c *Client) launch(ctx context.Context) error {
+	typ := EventStarted
+	if c.launched {
+		typ = EventRestarted
+	}
+	conn, err := c.openRetry(ctx, typ)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	if c.conf.jsonCodec {
+		c.rpc = newJSONClient(conn, c.conf.messageLimit())
+	} else {
+		c.rpc = newClientWithCodec(newGobClientCodec(conn, c.conf.bufferSize, c.conf.messageLimit()))
+	}
+	if err := sendSecrets(c.rpc, c.conf.secrets); err != nil {
+		c.rpc.Close()
+		c.rpc, c.conn = nil, nil
+		return err
+	}
+	c.lastCall = time.Now()
+	c.armIdle()
+	return nil
+}
+
+func (c *Client) openRetry(ctx context.Context, typ EventType) (io.ReadWriteCloser, error) {
+	if c.conf.startAttempts <= 1 {
+		return c.open(typ)
+	}
+	backoff := c.conf.startBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := c.open(typ)
+		if err == nil {
+			return conn, nil
+		}
+		if attempt == c.conf.startAttempts {
+			return nil, &StartRetryError{attempt, err}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, &StartRetryError{attempt, ctx.Err()}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) open(typ EventType) (io.ReadWriteCloser, error) {
+	if c.dial != nil {
+		conn, err := c.dial()
+		c.emit(typ, err)
+		c.launched = c.launched || err == nil
+		return conn, err
+	}
+	var shm *shmConn
+	var np *namedPipe
+	cmd, err := c.conf.command(c.path)
+	if err == nil && c.conf.shm != nil {
+		shm, err = c.conf.shm.open(true)
+	}
+	if err == nil && c.conf.namedPipe {
+		np, err = listenNamedPipe(cmd)
+	}
+	if err != nil {
+		if shm != nil {
+			shm.Close()
+		}
+		c.emit(typ, err)
+		return nil, err
+	}
+	pipe, err := start(cmd)
+	c.emit(typ, err)
+	if err != nil {
+		if shm != nil {
+			shm.Close()
+		}
+		if np != nil {
+			np.Close()
+		}
+		return nil, err
+	}
+	c.launched = true
+	if c.conf.killTimeout > 0 {
+		pipe.setStopTimeout(c.conf.killTimeout)
+	}
+	pipe.preKill = c.conf.preKill
+	c.pipe = pipe
+	c.exit = pipe.exit
+	var rw io.ReadWriteCloser = pipe
+	if shm != nil {
+		rw = c.conf.shm.attach(shm, pipe)
+	}
+	if np != nil {
+		if rw, err = np.attach(pipe); err != nil {
+			c.emit(EventHandshake, err)
+			return nil, err
+		}
+	}
+	conn, err := c.handshake(rw)
+	c.emit(EventHandshake, err)
+	return conn, err
+}
+
+func (c *Client) handshake(pipe io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	conn, manifest, err := hostHandshake(pipe, c.conf)
+	c.manifest = manifest
+	return conn, err
+}
+
+// hostHandshake runs the host's side of the handshake over pipe, giving up
+// after handshakeTimeout. pipe is closed if the handshake fails.
+func hostHandshake(pipe io.ReadWriteCloser, conf *config) (io.ReadWriteCloser, *Manifest, error) {
+	type result struct {
+		conn     io.ReadWriteCloser
+		manifest *Manifest
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, manifest, err := handshake(pipe, conf, nil)
+		done <- result{conn, manifest, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			pipe.Close()
+		}
+		return r.conn, r.manifest, r.err
+	case <-time.After(handshakeTimeout):
+		pipe.Close()
+		return nil, nil, HandshakeTimeoutError
+	}
+}
+
+func (c *Client) shutdown() error {
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+	if c.rpc == nil {
+		return nil
+	}
+	err := c.rpc.Close()
+	c.rpc, c.conn = nil, nil
+	c.emit(EventStopped, err)
+	return err
+}
+
+func (c *Client) acquire() (*rpc.Client, *Manifest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, ClientClosedError
+	}
+	if c.rpc == nil {
+		if err := c.launch(context.Background()); err != nil {
+			return nil, nil, err
+		}
+	}
+	c.inflight++
+	if c.using == nil {
+		c.using = make(map[*rpc.Client]int)
+	}
+	c.using[c.rpc]++
+	return c.rpc, c.manifest, nil
+}
+
+func (c *Client) release(client *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	if c.using[client]--; c.using[client] == 0 {
+		delete(c.using, client)
+		if done, ok := c.retired[client]; ok {
+			close(done)
+			delete(c.retired, client)
+		}
+	}
+	c.lastCall = time.Now()
+	c.armIdle()
+}
+
+func (c *Client) armIdle() {
+	if c.conf.idleTimeout <= 0 || c.inflight > 0 {
+		return
+	}
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	c.idle = time.AfterFunc(c.conf.idleTimeout, c.reap)
+}
+
+func (c *Client) reap() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.inflight > 0 || time.Since(c.lastCall) < c.conf.idleTimeout {
+		return
+	}
+	c.shutdown()
+}
@@ -0,0 +1,379 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeProc struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func newFakeProc() *fakeProc {
+	return &fakeProc{done: make(chan struct{})}
+}
+
+func (f *fakeProc) Wait() (*os.ProcessState, error) {
+	<-f.done
+	return nil, nil
+}
+
+func (f *fakeProc) Kill() error {
+	f.once.Do(func() { close(f.done) })
+	return nil
+}
+
+func (f *fakeProc) Signal(os.Signal) error {
+	return f.Kill()
+}
+
+type fakeCmd struct {
+	api       interface{}
+	opts      []Option
+	launches  *int32
+	startErr  error
+	block     chan struct{}
+	started   chan *fakeProc
+	proc      *fakeProc
+	inR, outR *io.PipeReader
+	inW, outW *io.PipeWriter
+}
+
+func (f *fakeCmd) StdinPipe() (io.WriteCloser, error) {
+	f.inR, f.inW = io.Pipe()
+	return f.inW, nil
+}
+
+func (f *fakeCmd) StdoutPipe() (io.ReadCloser, error) {
+	f.outR, f.outW = io.Pipe()
+	return f.outR, nil
+}
+
+func (f *fakeCmd) Start() (osProcess, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+	if f.launches != nil {
+		atomic.AddInt32(f.launches, 1)
+	}
+	p := newPlugin("Test", "", rwc(f.inR, f.outW), f.api, f.opts)
+	p.handshake = true
+	go p.Serve()
+	f.proc = newFakeProc()
+	if f.started != nil {
+		f.started <- f.proc
+	}
+	return f.proc, nil
+}
+
+func withFakePlugin(t *testing.T, api interface{}, opts ...Option) *int32 {
+	t.Helper()
+	launches := new(int32)
+	orig := makeCommand
+	makeCommand = func(io.Writer, string, []string) commander {
+		return &fakeCmd{api: api, opts: opts, launches: launches}
+	}
+	t.Cleanup(func() { makeCommand = orig })
+	return launches
+}
+
+func TestStartLazy(t *testing.T) {
+	launches := withFakePlugin(t, newTestAPI())
+	c := StartLazy("test")
+	defer c.Close()
+	if n := atomic.LoadInt32(launches); n != 0 {
+		t.Fatalf("expected no launch before first call, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply string
+			if err := c.Call("Test.Echo", "hi", &reply); err != nil || reply != "hi" {
+				t.Errorf("unexpected call result %q, %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(launches); n != 1 {
+		t.Errorf("expected exactly one launch, got %d", n)
+	}
+}
+
+func TestStartLazyIdleTimeout(t *testing.T) {
+	launches := withFakePlugin(t, newTestAPI())
+	c := StartLazy("test", IdleTimeout(20*time.Millisecond))
+	defer c.Close()
+
+	var reply string
+	for i := 0; i < 2; i++ {
+		if err := c.Call("Test.Echo", "hi", &reply); err != nil {
+			t.Fatalf("unexpected call error: %s", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(launches); n != 2 {
+		t.Errorf("expected a relaunch after idling, got %d launches", n)
+	}
+}
+
+func TestMethodTimeout(t *testing.T) {
+	api := newTestAPI()
+	defer close(api.release)
+	withFakePlugin(t, api)
+	c, err := NewClient("test",
+		MethodTimeout("Test.Slow", 20*time.Millisecond),
+		MethodTimeout("Test.Echo", time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var reply string
+	if err := c.Call("Test.Echo", "fast", &reply); err != nil || reply != "fast" {
+		t.Errorf("fast call: unexpected result %q, %v", reply, err)
+	}
+	if err := c.Call("Test.Slow", "slow", &reply); err != MethodTimeoutError {
+		t.Errorf("slow call: expected MethodTimeoutError, got %v", err)
+	}
+	start := time.Now()
+	if err := c.CallCtx(context.Background(), "Test.Slow", "slow", &reply); err != MethodTimeoutError {
+		t.Errorf("slow CallCtx: expected MethodTimeoutError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("slow CallCtx ignored the method timeout, took %s", elapsed)
+	}
+}
+
+func TestClientUsage(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "hi", &reply); err != nil || reply != "hi" {
+		t.Fatalf("unexpected call result %q, %v", reply, err)
+	}
+	if _, err := c.Usage(); err != ProcessRunningError {
+		t.Errorf("expected ProcessRunningError before exit, got %v", err)
+	}
+	c.Close()
+	usage, err := c.Usage()
+	if err == UsageNotSupportedError {
+		t.Skip(err)
+	}
+	if err != nil || usage.MaxRSS <= 0 {
+		t.Errorf("expected usage after exit, got %+v, %v", usage, err)
+	}
+}
+
+func TestClientReadDeadline(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SetReadDeadline(time.Now().Add(-time.Second)); err == DeadlineNotSupportedError {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "hi", &reply); !errors.As(err, new(*TransportError)) {
+		t.Errorf("expected call to fail once the read deadline passed, got %v", err)
+	}
+}
+
+func TestCaptureStderrPipe(t *testing.T) {
+	path, args := helperProcess(t, "stderr")
+	c, err := NewClient(path, WithArgs(args...), CaptureStderrPipe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	line, err := bufio.NewReader(c.Stderr()).ReadString('\n')
+	if err != nil || line != "hello stderr\n" {
+		t.Errorf("expected plugin stderr to be readable, got %q, %v", line, err)
+	}
+}
+
+func TestFromConn(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	served := make(chan struct{})
+	go func() {
+		p.Serve()
+		close(served)
+	}()
+
+	c := NewClientFromConn(host)
+	var reply string
+	if err := c.Call("Test.Echo", "conn", &reply); err != nil || reply != "conn" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	c.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("expected closing the client to close the conn and end serving")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	launches := withFakePlugin(t, newTestAPI())
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "relaunched", &reply); err != nil || reply != "relaunched" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if n := atomic.LoadInt32(launches); n != 2 {
+		t.Errorf("expected Reconnect to relaunch the plugin, got %d launches", n)
+	}
+	c.Close()
+	if err := c.Reconnect(); err != ClientClosedError {
+		t.Errorf("expected ClientClosedError after Close, got %v", err)
+	}
+}
+
+func TestDialClientReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	accepted := new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go NewFromConn("Test", conn, newTestAPI()).Serve()
+		}
+	}()
+
+	c, err := DialClient("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "redialled", &reply); err != nil || reply != "redialled" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if n := atomic.LoadInt32(accepted); n != 2 {
+		t.Errorf("expected Reconnect to dial again, got %d connections", n)
+	}
+
+	l.Close()
+	if err := c.Reconnect(); err == nil {
+		t.Error("expected Reconnect to fail once the plugin stopped listening")
+	}
+}
+
+func TestStartRetry(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	fake := makeCommand
+	failures := 2
+	makeCommand = func(w io.Writer, path string, args []string) commander {
+		if failures > 0 {
+			failures--
+			return &fakeCmd{startErr: os.ErrNotExist}
+		}
+		return fake(w, path, args)
+	}
+
+	c, err := NewClient("test", WithStartRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected launch to succeed on the third attempt, got %v", err)
+	}
+	c.Close()
+
+	failures = 3
+	_, err = NewClient("test", WithStartRetry(3, time.Millisecond))
+	var retryErr *StartRetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected StartRetryError after 3 attempts, got %v", err)
+	}
+}
+
+func TestStartRetryCancel(t *testing.T) {
+	orig := makeCommand
+	makeCommand = func(io.Writer, string, []string) commander {
+		return &fakeCmd{startErr: os.ErrNotExist}
+	}
+	defer func() { makeCommand = orig }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := NewClientContext(ctx, "test", WithStartRetry(100, time.Hour))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled retries, got %v", err)
+	}
+}
+
+func TestCallCtx(t *testing.T) {
+	api := newTestAPI()
+	withFakePlugin(t, api)
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var reply string
+	if err := c.CallCtx(context.Background(), "Test.Echo", "hi", &reply); err != nil || reply != "hi" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reply = ""
+	canceled := make(chan error, 1)
+	go func() { canceled <- c.CallCtx(ctx, "Test.Slow", "late", &reply) }()
+	<-api.started
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected an error wrapping context.Canceled, got %v", err)
+	}
+	close(api.release)
+	var echo string
+	if err := c.Call("Test.Echo", "after", &echo); err != nil || echo != "after" {
+		t.Fatalf("unexpected result %q, %v", echo, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if reply != "" {
+		t.Errorf("expected reply untouched after cancellation, got %q", reply)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := c.CallCtx(ctx, "Test.Echo", "expired", &reply); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected an error wrapping context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync/atomic"
+)
+
+var PluginClosedError = Xrror("plugin closed its connection mid-call")
+
+// newClient is rpc.NewClient with end of stream reported as
+// PluginClosedError, see closedCodec, and replies limited to maxMessage
+// bytes, or unlimited for 0.
+func newClient(conn io.ReadWriteCloser, maxMessage int) *rpc.Client {
+	return newClientWithCodec(newGobClientCodec(conn, 0, maxMessage))
+}
+
+// newJSONClient is newClient for the JSON-RPC codec.
+func newJSONClient(conn io.ReadWriteCloser, maxMessage int) *rpc.Client {
+	return newClientWithCodec(jsonrpc.NewClientCodec(limitJSON(conn, maxMessage)))
+}
+
+func newClientWithCodec(codec rpc.ClientCodec) *rpc.Client {
+	return rpc.NewClientWithCodec(&closedCodec{ClientCodec: codec})
+}
+
+// closedCodec turns the EOF or short read of a plugin that exits partway
+// through a reply into PluginClosedError, which rpc.Client then fails all
+// pending calls with, instead of a decode error naming neither cause.
+// Errors after the host itself closed the codec pass through.
+type closedCodec struct {
+	rpc.ClientCodec
+	closing int32
+}
+
+func (c *closedCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.translate(c.ClientCodec.ReadResponseHeader(r))
+}
+
+func (c *closedCodec) ReadResponseBody(body interface{}) error {
+	return c.translate(c.ClientCodec.ReadResponseBody(body))
+}
+
+func (c *closedCodec) Close() error {
+	atomic.StoreInt32(&c.closing, 1)
+	return c.ClientCodec.Close()
+}
+
+func (c *closedCodec) translate(err error) error {
+	if (err == io.EOF || err == io.ErrUnexpectedEOF) && atomic.LoadInt32(&c.closing) == 0 {
+		return PluginClosedError
+	}
+	return err
+}
+
+type gobClientCodec struct {
+	rwc      io.ReadWriteCloser
+	dec      *gob.Decoder
+	enc      *gob.Encoder
+	encBuf   *bufio.Writer
+	limit    *gobLimiter
+	skipBody bool
+}
+
+func newGobClientCodec(rwc io.ReadWriteCloser, bufferSize, maxMessage int) rpc.ClientCodec {
+	r, w := buffers(rwc, bufferSize)
+	c := &gobClientCodec{rwc: rwc, enc: gob.NewEncoder(w), encBuf: w}
+	lr := limitGob(r, maxMessage)
+	c.limit, _ = lr.(*gobLimiter)
+	c.dec = gob.NewDecoder(lr)
+	return c
+}
+
+const defaultBufferSize = 4096
+
+// WithBufferSize sets the size of the read and write buffers the gob codec
+// wraps the connection in, on whichever end it is given to, by default
+// 4096 bytes. Each message is flushed as soon as it is encoded, so larger
+// buffers save syscalls on large messages without delaying small ones.
+// Codecs passed to ServeCodec or StartCodec do their own buffering.
+func WithBufferSize(n int) Option {
+	return func(c *config) {
+		c.bufferSize = n
+	}
+}
+
+func buffers(rwc io.ReadWriter, size int) (*bufio.Reader, *bufio.Writer) {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return bufio.NewReaderSize(rwc, size), bufio.NewWriterSize(rwc, size)
+}
+
+func (c *gobClientCodec) WriteRequest(r *rpc.Request, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+// ReadResponseHeader checks the size of the reply that follows the header
+// before rpc.Client reads it, as rpc.Client drops the connection when
+// reading a body fails. An oversize reply is skipped and reported as the
+// call's error instead.
+func (c *gobClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	if err := c.dec.Decode(r); err != nil || c.limit == nil {
+		return err
+	}
+	switch err := c.limit.begin(); err {
+	case nil:
+	case MessageTooLargeError:
+		r.Error, c.skipBody = err.Error(), true
+	default:
+		return err
+	}
+	return nil
+}
+
+func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
+	if c.skipBody {
+		c.skipBody = false
+		return nil
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *gobClientCodec) Close() error {
+	return c.rwc.Close()
+}
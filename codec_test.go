@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// syscallConn counts the reads and writes made on a conn, each of which
+// is a syscall on a real pipe.
+type syscallConn struct {
+	net.Conn
+	ops *int64
+}
+
+func (c syscallConn) Read(p []byte) (int, error) {
+	atomic.AddInt64(c.ops, 1)
+	return c.Conn.Read(p)
+}
+
+func (c syscallConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.ops, 1)
+	return c.Conn.Write(p)
+}
+
+func TestWithBufferSize(t *testing.T) {
+	host, conn := net.Pipe()
+	go newPlugin("Test", "", conn, newTestAPI(), []Option{WithBufferSize(64)}).Serve()
+	client := newClientWithCodec(newGobClientCodec(host, 64, 0))
+	defer client.Close()
+	var reply string
+	big := strings.Repeat("x", 1000)
+	if err := client.Call("Test.Echo", big, &reply); err != nil || reply != big {
+		t.Errorf("expected a message larger than the buffers through, got %d bytes, %v", len(reply), err)
+	}
+}
+
+func BenchmarkBufferSize(b *testing.B) {
+	arg := strings.Repeat("x", 8<<10)
+	for _, size := range []int{64, defaultBufferSize, 64 << 10} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			host, conn := net.Pipe()
+			go newPlugin("Test", "", conn, newTestAPI(), []Option{WithBufferSize(size)}).Serve()
+			ops := new(int64)
+			client := newClientWithCodec(newGobClientCodec(syscallConn{host, ops}, size, 0))
+			defer client.Close()
+			b.SetBytes(int64(len(arg)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var reply string
+				if err := client.Call("Test.Echo", arg, &reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(ops))/float64(b.N), "syscalls/op")
+		})
+	}
+}
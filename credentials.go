@@ -0,0 +1,24 @@
+package plugin
+
+var (
+	ErrIncompleteCredentials = Xrror("WithUID and WithGID must be used together")
+	ErrNotSupported          = Xrror("option is not supported on this platform")
+)
+
+// WithUID runs the plugin process as user uid. It must be paired with
+// WithGID, and the host needs the privilege to switch users.
+func WithUID(uid int) Option {
+	return func(c *config) {
+		id := uint32(uid)
+		c.uid = &id
+	}
+}
+
+// WithGID runs the plugin process as group gid. It must be paired with
+// WithUID; either alone fails the launch with ErrIncompleteCredentials.
+func WithGID(gid int) Option {
+	return func(c *config) {
+		id := uint32(gid)
+		c.gid = &id
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package plugin
+
+import "os/exec"
+
+func (c *config) setCredentials(*exec.Cmd) error {
+	if c.uid != nil || c.gid != nil {
+		return ErrNotSupported
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package plugin
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIncompleteCredentials(t *testing.T) {
+	if _, err := NewClient("plugin", WithUID(65534)); err != ErrIncompleteCredentials {
+		t.Errorf("expected ErrIncompleteCredentials, got %v", err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	path, args := helperProcess(t, "uid")
+	c, err := NewClient(path, WithArgs(args...), WithUID(65534), WithGID(65534))
+	if err != nil {
+		t.Skipf("could not launch test binary as nobody: %s", err)
+	}
+	defer c.Close()
+	var euid int
+	if err := c.Call("Test.Geteuid", 0, &euid); err != nil || euid != 65534 {
+		t.Errorf("expected plugin euid 65534, got %d, %v", euid, err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func (c *config) setCredentials(cmd *exec.Cmd) error {
+	if c.uid == nil && c.gid == nil {
+		return nil
+	}
+	if c.uid == nil || c.gid == nil {
+		return ErrIncompleteCredentials
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: *c.uid, Gid: *c.gid}
+	return nil
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"time"
+)
+
+type readDeadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+var DeadlineNotSupportedError = Xrror("plugin transport does not support deadlines")
+
+func setReadDeadline(v interface{}, t time.Time) error {
+	if d, ok := v.(readDeadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return DeadlineNotSupportedError
+}
+
+func setWriteDeadline(v interface{}, t time.Time) error {
+	if d, ok := v.(writeDeadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return DeadlineNotSupportedError
+}
+
+func (iop *ioPipe) SetReadDeadline(t time.Time) error {
+	return setReadDeadline(iop.ReadCloser, t)
+}
+
+func (iop *ioPipe) SetWriteDeadline(t time.Time) error {
+	return setWriteDeadline(iop.WriteCloser, t)
+}
+
+func (f *flateConn) SetReadDeadline(t time.Time) error {
+	return setReadDeadline(f.rwc, t)
+}
+
+func (f *flateConn) SetWriteDeadline(t time.Time) error {
+	return setWriteDeadline(f.rwc, t)
+}
+
+// SetReadDeadline bounds how long the client waits on the plugin's replies,
+// so a stuck plugin can't block it forever. Passing the deadline fails the
+// calls in flight and breaks the connection. The host's ends of the stdio
+// pipes accept deadlines on systems that can poll pipes, such as Linux and
+// the BSDs; elsewhere, and on the plugin's side of stdio, blocking I/O can't
+// be interrupted and DeadlineNotSupportedError is returned. Transports built
+// on a net.Conn always support deadlines.
+func (c *Client) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return setReadDeadline(c.conn, t)
+}
+
+func (c *Client) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return setWriteDeadline(c.conn, t)
+}
+
+func (c *Client) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+)
+
+// NewDebugPlugin is New with net/http/pprof served on localhost:debugPort,
+// or on a free port if debugPort is 0, until the plugin is closed. The
+// server's address is logged to standard error, where the host sees it,
+// and returned by DebugURL.
+func NewDebugPlugin(name, path string, api interface{}, debugPort int) *Plugin {
+	p := New(name, path, api)
+	if err := p.serveDebug(debugPort); err != nil {
+		log.Fatalf("failed to serve Plugin %s debug handlers: %s", p.label(), err)
+	}
+	log.Printf("plugin %s serving pprof at %s", p.label(), p.DebugURL())
+	return p
+}
+
+// DebugURL returns the base URL of the plugin's pprof handlers, or "" if it
+// was not created with NewDebugPlugin.
+func (p *Plugin) DebugURL() string {
+	if p.debug == nil {
+		return ""
+	}
+	return "http://" + p.debug.Addr + "/debug/pprof/"
+}
+
+func (p *Plugin) serveDebug(port int) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	p.debug = &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go p.debug.Serve(ln)
+	return nil
+}
@@ -0,0 +1,27 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDebugPlugin(t *testing.T) {
+	p, _ := pipePlugin(t, newTestAPI())
+	if err := p.serveDebug(0); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(p.DebugURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected pprof index, got %d", resp.StatusCode)
+	}
+
+	p.Close()
+	if resp, err := http.Get(p.DebugURL()); err == nil {
+		resp.Body.Close()
+		t.Error("expected the debug server to stop when the plugin is closed")
+	}
+}
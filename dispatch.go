@@ -0,0 +1,330 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+type methodType struct {
+	method    reflect.Method
+	argType   reflect.Type
+	replyType reflect.Type
+}
+
+type service struct {
+	name    string
+	rcvr    reflect.Value
+	methods map[string]*methodType
+}
+
+func newService(name string, rcvr interface{}) *service {
+	s := &service{
+		name:    name,
+		rcvr:    reflect.ValueOf(rcvr),
+		methods: make(map[string]*methodType),
+	}
+	typ := s.rcvr.Type()
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		mt := m.Type
+		if m.PkgPath != "" || mt.NumIn() != 3 || mt.NumOut() != 1 {
+			continue
+		}
+		if mt.In(2).Kind() != reflect.Ptr || mt.Out(0) != typeOfError {
+			continue
+		}
+		s.methods[m.Name] = &methodType{method: m, argType: mt.In(1), replyType: mt.In(2)}
+	}
+	return s
+}
+
+func (s *service) call(mt *methodType, argv, replyv reflect.Value) error {
+	out := mt.method.Func.Call([]reflect.Value{s.rcvr, argv, replyv})
+	if err := out[0].Interface(); err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+var (
+	ShuttingDownError    = Xrror("plugin is shutting down, retry elsewhere")
+	CallRejectedError    = ShuttingDownError // the former name of ShuttingDownError
+	DrainTimeoutError    = Xrror("timed out waiting for in-progress calls to finish")
+	ServiceDefinedError  = Xrror("rpc: service already defined: %s").Out
+	ServiceNotFoundError = Xrror("rpc: can't find service %s").Out
+	invalidRequest       = struct{}{}
+)
+
+type dispatcher struct {
+	mu         sync.RWMutex
+	services   map[string]*service
+	limiter    *rateLimiter
+	middleware []Middleware
+	userCalls  int32
+
+	// calls guards inflight together with draining and drained: a call
+	// must not be counted in after drain has seen none in flight, which
+	// an atomic counter checked apart from the flag would allow.
+	calls    sync.Mutex
+	inflight int
+	draining bool
+	drained  chan struct{}
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{services: make(map[string]*service)}
+}
+
+func (d *dispatcher) register(name string, rcvr interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.services[name]; ok {
+		return ServiceDefinedError(name)
+	}
+	d.services[name] = newService(name, rcvr)
+	return nil
+}
+
+func (d *dispatcher) replace(name string, rcvr interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.services[name]; !ok {
+		return ServiceNotFoundError(name)
+	}
+	d.services[name] = newService(name, rcvr)
+	return nil
+}
+
+func (d *dispatcher) unregister(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.services[name]; !ok {
+		return ServiceNotFoundError(name)
+	}
+	delete(d.services, name)
+	return nil
+}
+
+func (d *dispatcher) lookup(serviceMethod string) (*service, *methodType, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, Xrror("rpc: service/method request ill-formed: %s").Out(serviceMethod)
+	}
+	d.mu.RLock()
+	s, ok := d.services[serviceMethod[:dot]]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, nil, Xrror("rpc: can't find service %s").Out(serviceMethod)
+	}
+	mt, ok := s.methods[serviceMethod[dot+1:]]
+	if !ok {
+		return nil, nil, Xrror("rpc: can't find method %s").Out(serviceMethod)
+	}
+	return s, mt, nil
+}
+
+func (d *dispatcher) begin() bool {
+	d.calls.Lock()
+	defer d.calls.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight++
+	return true
+}
+
+func (d *dispatcher) end() {
+	d.calls.Lock()
+	defer d.calls.Unlock()
+	d.inflight--
+	if d.inflight == 0 && d.drained != nil {
+		close(d.drained)
+		d.drained = nil
+	}
+}
+
+func (d *dispatcher) beginDrain() {
+	d.calls.Lock()
+	defer d.calls.Unlock()
+	d.draining = true
+}
+
+func (d *dispatcher) drain(timeout time.Duration) error {
+	d.calls.Lock()
+	d.draining = true
+	if d.inflight == 0 {
+		d.calls.Unlock()
+		return nil
+	}
+	if d.drained == nil {
+		d.drained = make(chan struct{})
+	}
+	drained := d.drained
+	d.calls.Unlock()
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(timeout):
+		return DrainTimeoutError
+	}
+}
+
+func (d *dispatcher) serveCodec(codec rpc.ServerCodec) error {
+	sending := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	var readErr error
+	for {
+		var req rpc.Request
+		if readErr = codec.ReadRequestHeader(&req); readErr != nil {
+			break
+		}
+		s, mt, err := d.lookup(req.ServiceMethod)
+		if err == nil && !d.begin() {
+			err = ShuttingDownError
+		}
+		if err != nil {
+			if readErr = codec.ReadRequestBody(nil); readErr != nil {
+				break
+			}
+			respond(sending, codec, req, invalidRequest, err)
+			continue
+		}
+		argv, replyv, err := readArgs(codec, mt)
+		if err != nil {
+			respond(sending, codec, req, invalidRequest, err)
+			d.end()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer d.end()
+			err := d.invoke(s, mt, argv, replyv)
+			respond(sending, codec, req, replyv.Interface(), err)
+		}()
+	}
+	wg.Wait()
+	flush(codec)
+	codec.Close()
+	return readErr
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// flush flushes a codec that buffers its writes itself rather than per
+// response, so nothing is lost when it is closed.
+func flush(v interface{}) {
+	if f, ok := v.(flusher); ok {
+		f.Flush()
+	}
+}
+
+func (d *dispatcher) invoke(s *service, mt *methodType, argv, replyv reflect.Value) error {
+	if err := d.limiter.wait(); err != nil {
+		return err
+	}
+	return d.handler(s, mt)(argv.Interface(), replyv.Interface())
+}
+
+func readArgs(codec rpc.ServerCodec, mt *methodType) (argv, replyv reflect.Value, err error) {
+	return decodeArgs(codec.ReadRequestBody, mt)
+}
+
+func decodeArgs(decode func(interface{}) error, mt *methodType) (argv, replyv reflect.Value, err error) {
+	argIsValue := false
+	if mt.argType.Kind() == reflect.Ptr {
+		argv = reflect.New(mt.argType.Elem())
+	} else {
+		argv = reflect.New(mt.argType)
+		argIsValue = true
+	}
+	if err = decode(argv.Interface()); err != nil {
+		return
+	}
+	if argIsValue {
+		argv = argv.Elem()
+	}
+	replyv = reflect.New(mt.replyType.Elem())
+	switch mt.replyType.Elem().Kind() {
+	case reflect.Map:
+		replyv.Elem().Set(reflect.MakeMap(mt.replyType.Elem()))
+	case reflect.Slice:
+		replyv.Elem().Set(reflect.MakeSlice(mt.replyType.Elem(), 0, 0))
+	}
+	return
+}
+
+func respond(sending *sync.Mutex, codec rpc.ServerCodec, req rpc.Request, reply interface{}, err error) {
+	resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+	if err != nil {
+		resp.Error = err.Error()
+		reply = invalidRequest
+	}
+	sending.Lock()
+	codec.WriteResponse(resp, reply)
+	sending.Unlock()
+}
+
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	return newGobServerCodecSize(rwc, 0, defaultMaxMessageSize)
+}
+
+func newGobServerCodecSize(rwc io.ReadWriteCloser, bufferSize, maxMessage int) rpc.ServerCodec {
+	r, w := buffers(rwc, bufferSize)
+	return &gobServerCodec{
+		rwc:    rwc,
+		dec:    gob.NewDecoder(limitGob(r, maxMessage)),
+		enc:    gob.NewEncoder(w),
+		encBuf: w,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAPI struct {
+	started, release chan struct{}
+}
+
+func (a *testAPI) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func (a *testAPI) Slow(args string, reply *string) error {
+	a.started <- struct{}{}
+	<-a.release
+	*reply = args
+	return nil
+}
+
+func newTestAPI() *testAPI {
+	return &testAPI{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func pipePlugin(t *testing.T, api interface{}) (*Plugin, *rpc.Client) {
+	t.Helper()
+	p, client := NewInProcess("Test", api)
+	t.Cleanup(func() { client.Close() })
+	return p, client
+}
+
+func TestDrain(t *testing.T) {
+	api := newTestAPI()
+	p, client := pipePlugin(t, api)
+
+	var slow string
+	call := client.Go("Test.Slow", "slow", &slow, nil)
+	<-api.started
+
+	drained := make(chan error, 1)
+	go func() { drained <- p.Drain(time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+
+	var reply string
+	if err := client.Call("Test.Echo", "late", &reply); err == nil || err.Error() != CallRejectedError.Error() {
+		t.Errorf("expected %q for call after Drain, got %v", CallRejectedError, err)
+	}
+
+	close(api.release)
+	if err := <-drained; err != nil {
+		t.Errorf("unexpected drain error: %s", err)
+	}
+	if (<-call.Done).Error != nil || slow != "slow" {
+		t.Errorf("in-progress call did not complete: %v %q", call.Error, slow)
+	}
+}
+
+func TestBeginDrain(t *testing.T) {
+	api := newTestAPI()
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, api)
+	go p.Serve()
+	c := NewClientFromConn(host)
+	defer c.Close()
+
+	var slow string
+	inflight := make(chan error, 1)
+	go func() { inflight <- c.Call("Test.Slow", "slow", &slow) }()
+	<-api.started
+	p.BeginDrain()
+
+	var reply string
+	if err := c.Call("Test.Echo", "late", &reply); err != ShuttingDownError {
+		t.Errorf("expected ShuttingDownError for a call after BeginDrain, got %v", err)
+	}
+	close(api.release)
+	if err := <-inflight; err != nil || slow != "slow" {
+		t.Errorf("expected the in-progress call to complete, got %q, %v", slow, err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	api := newTestAPI()
+	p, client := pipePlugin(t, api)
+	defer close(api.release)
+
+	var slow string
+	client.Go("Test.Slow", "slow", &slow, nil)
+	<-api.started
+	if err := p.Drain(10 * time.Millisecond); err != DrainTimeoutError {
+		t.Errorf("expected DrainTimeoutError, got %v", err)
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	p, client := pipePlugin(t, newTestAPI())
+	var reply string
+	if err := client.Call("Test.Echo", "before", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unregister("Test"); err != nil {
+		t.Fatal(err)
+	}
+	err := client.Call("Test.Echo", "after", &reply)
+	if _, ok := err.(rpc.ServerError); !ok || !strings.Contains(err.Error(), "can't find service") {
+		t.Errorf("expected a can't find service error after Unregister, got %v", err)
+	}
+	if err := p.Unregister("Test"); err == nil {
+		t.Error("expected an error unregistering an unknown service")
+	}
+
+	if err := p.RegisterName("Test", newTestAPI()); err != nil {
+		t.Fatalf("expected the service to register again, got %v", err)
+	}
+	if err := client.Call("Test.Echo", "again", &reply); err != nil || reply != "again" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+type shoutAPI struct{}
+
+func (shoutAPI) Echo(args string, reply *string) error {
+	*reply = strings.ToUpper(args)
+	return nil
+}
+
+func (shoutAPI) Slow(args string, reply *string) error {
+	return shoutAPI{}.Echo(args, reply)
+}
+
+func TestHotReload(t *testing.T) {
+	api := newTestAPI()
+	p, client := pipePlugin(t, api)
+
+	var slow string
+	call := client.Go("Test.Slow", "old", &slow, nil)
+	<-api.started
+	if err := p.HotReload(shoutAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Test.Echo", "new", &reply); err != nil || reply != "NEW" {
+		t.Errorf("expected the new implementation, got %q, %v", reply, err)
+	}
+	close(api.release)
+	if <-call.Done; call.Error != nil || slow != "old" {
+		t.Errorf("expected the in-flight call to finish on the old implementation, got %q, %v", slow, call.Error)
+	}
+}
+
+func TestServeUntil(t *testing.T) {
+	_, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI(), ServePollInterval(10*time.Millisecond))
+	deadline := time.Now().Add(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		p.ServeUntil(func() bool { return time.Now().After(deadline) })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("expected serving to stop once the condition held")
+	}
+}
+
+func TestServeError(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	served := make(chan error, 1)
+	go func() { served <- p.Serve() }()
+	client := rpc.NewClient(host)
+	var reply string
+	if err := client.Call("Test.Echo", "hi", &reply); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-served; err != nil {
+		t.Errorf("expected a clean hang-up to return nil, got %v", err)
+	}
+
+	host, conn = net.Pipe()
+	p = NewFromConn("Test", conn, newTestAPI())
+	go func() { served <- p.Serve() }()
+	host.Write([]byte("not gob\n"))
+	host.Close()
+	if err := <-served; err == nil {
+		t.Error("expected a codec error to be returned")
+	}
+}
+
+func TestServeN(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	served := make(chan error, 1)
+	go func() { served <- p.ServeN(3) }()
+	client := rpc.NewClient(host)
+	defer client.Close()
+	var reply string
+	for i := 0; i < 3; i++ {
+		if err := client.Call("Test.Echo", "hi", &reply); err != nil {
+			t.Fatalf("call %d: %s", i+1, err)
+		}
+	}
+	if err := client.Call("Test.Echo", "one too many", &reply); err == nil {
+		t.Error("expected the call after the n-th to fail")
+	} else if _, ok := err.(rpc.ServerError); ok {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected serve error: %s", err)
+	}
+}
+
+func TestWithServiceName(t *testing.T) {
+	p, client := NewInProcess("shouter-v2", newTestAPI(), WithServiceName("Test"))
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "service", &reply); err != nil || reply != "service" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	if err := client.Call("shouter-v2.Echo", "name", &reply); err == nil {
+		t.Error("expected the plugin's name not to be a service")
+	}
+	if err := p.HotReload(shoutAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Test.Echo", "reloaded", &reply); err != nil || reply != "RELOADED" {
+		t.Errorf("expected HotReload to replace the named service, got %q, %v", reply, err)
+	}
+}
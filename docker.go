@@ -0,0 +1,342 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DockerOptions configures the container RunPluginInDocker runs a plugin
+// in. Mounts are bind mounts in docker's "source:target[:ro]" form,
+// CPULimit is in CPUs and MemLimit in bytes; zero limits are unlimited.
+type DockerOptions struct {
+	Image       string
+	Mounts      []string
+	NetworkMode string
+	CPULimit    float64
+	MemLimit    int64
+	// MaxMessageSize limits the replies the host reads, as
+	// WithMaxMessageSize: 0 for the default, negative for no limit.
+	MaxMessageSize int
+}
+
+var DockerError = Xrror("docker %s: %s").Out
+
+// RunPluginInDocker runs a plugin in a new container of image, or of
+// opts.Image if image is empty, with args as its command, and returns a
+// client talking to it over the container's attached stdin and stdout. The
+// plugin's stderr is copied to os.Stderr. The Docker Engine API is spoken
+// directly, at DOCKER_HOST or the local socket by default. Closing the
+// client stops the container, which docker then removes.
+func RunPluginInDocker(image string, opts DockerOptions, args ...string) (*rpc.Client, error) {
+	if image != "" {
+		opts.Image = image
+	}
+	d, err := newDockerAPI(os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		return nil, err
+	}
+	pipe, err := d.run(opts, args, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(pipe, (&config{maxMessageSize: opts.MaxMessageSize}).messageLimit()), nil
+}
+
+type dockerAPI struct {
+	network, addr string
+	http          *http.Client
+}
+
+func newDockerAPI(host string) (*dockerAPI, error) {
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	d := &dockerAPI{network: u.Scheme, addr: u.Host}
+	switch u.Scheme {
+	case "unix":
+		d.addr = u.Path
+	case "tcp":
+	default:
+		return nil, DockerError("host", "unsupported DOCKER_HOST "+host)
+	}
+	d.http = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, d.network, d.addr)
+		},
+	}}
+	return d, nil
+}
+
+func (d *dockerAPI) create(opts DockerOptions, args []string) (string, error) {
+	body := map[string]interface{}{
+		"Image":        opts.Image,
+		"Cmd":          args,
+		"OpenStdin":    true,
+		"StdinOnce":    true,
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"HostConfig": map[string]interface{}{
+			"Binds":       opts.Mounts,
+			"NetworkMode": opts.NetworkMode,
+			"NanoCpus":    int64(opts.CPULimit * 1e9),
+			"Memory":      opts.MemLimit,
+			"AutoRemove":  true,
+		},
+	}
+	var created struct{ Id string }
+	if err := d.post("/containers/create", body, &created); err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+// run creates a container, attaches to its stdio and starts it. The wait
+// for it is made before it starts: with AutoRemove the container may be
+// gone by the time it is asked about, and a later wait would find nothing
+// and lose its exit status.
+func (d *dockerAPI) run(opts DockerOptions, args []string, stderr io.Writer) (*ioPipe, error) {
+	id, err := d.create(opts, args)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := d.wait(id)
+	var pipe *ioPipe
+	if err == nil {
+		pipe, err = d.attach(proc, stderr)
+	}
+	if err == nil {
+		err = d.post("/containers/"+id+"/start", nil, nil)
+	}
+	if err != nil {
+		// Removing the container ends the wait, so closing the pipe
+		// does not sit out the stop timeout on it.
+		d.remove(id)
+		if pipe != nil {
+			pipe.Close()
+		}
+		return nil, err
+	}
+	return pipe, nil
+}
+
+// wait asks docker to report when the container has been removed, which
+// AutoRemove does once it exits, and returns the container as a process
+// whose Wait gives the outcome. It returns once docker has taken the
+// request, before any reply.
+func (d *dockerAPI) wait(id string) (*dockerProcess, error) {
+	path := "/containers/" + id + "/wait?condition=removed"
+	resp, err := d.http.Post("http://docker"+path, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, DockerError(path, strings.TrimSpace(string(msg)))
+	}
+	p := &dockerProcess{api: d, id: id, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		defer resp.Body.Close()
+		var result struct {
+			StatusCode int
+			Error      *struct{ Message string }
+		}
+		switch err := json.NewDecoder(resp.Body).Decode(&result); {
+		case err != nil:
+			p.err = err
+		case result.Error != nil && result.Error.Message != "":
+			p.err = DockerError("wait", result.Error.Message)
+		// 130 is a container that exited on the interrupt sent by Close.
+		case result.StatusCode != 0 && result.StatusCode != 130:
+			p.err = &AbnormalExitError{Code: result.StatusCode}
+		}
+	}()
+	return p, nil
+}
+
+func (d *dockerAPI) post(path string, body, reply interface{}) error {
+	_, err := d.call(path, body, reply)
+	return err
+}
+
+// call posts body to path, decoding the response into reply, and returns
+// the response status along with any error.
+func (d *dockerAPI) call(path string, body, reply interface{}) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+	resp, err := d.http.Post("http://docker"+path, "application/json", r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, DockerError(path, strings.TrimSpace(string(msg)))
+	}
+	if reply != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(reply)
+	}
+	return resp.StatusCode, nil
+}
+
+// gone reports whether status says the container has already stopped or
+// been removed.
+func gone(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusConflict
+}
+
+func (d *dockerAPI) remove(id string) {
+	req, err := http.NewRequest(http.MethodDelete, "http://docker/containers/"+id+"?force=1", nil)
+	if err != nil {
+		return
+	}
+	if resp, err := d.http.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// attach hijacks a connection to the stdio of proc's container, as the
+// attach endpoint requires, and returns it as a pipe whose process is
+// proc. Stdout and stderr arrive multiplexed and are split apart, stderr
+// going to stderr.
+func (d *dockerAPI) attach(proc *dockerProcess, stderr io.Writer) (*ioPipe, error) {
+	conn, err := net.Dial(d.network, d.addr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://docker/containers/"+proc.id+"/attach?stream=1&stdin=1&stdout=1&stderr=1", nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, DockerError("attach", resp.Status)
+	}
+	stdout, w := io.Pipe()
+	go func() {
+		w.CloseWithError(demuxDocker(br, w, stderr))
+	}()
+	return &ioPipe{
+		ReadCloser:  attachedReader{stdout, conn},
+		WriteCloser: halfCloser{conn},
+		proc:        proc,
+		exit:        new(exitStatus),
+	}, nil
+}
+
+// demuxDocker splits the stream of an attached container without a
+// terminal, where each chunk of output has an 8 byte header giving the
+// stream it came from and its length.
+func demuxDocker(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if w == nil {
+			w = io.Discard
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// halfCloser closes only the write side of conn, so the container sees
+// EOF on stdin while its last output can still be read. The conn itself
+// is closed along with the read side, by attachedReader.
+type halfCloser struct {
+	net.Conn
+}
+
+func (h halfCloser) Close() error {
+	if cw, ok := h.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return h.Conn.Close()
+}
+
+// attachedReader is the container's demultiplexed stdout, closing the
+// attach connection when it is closed.
+type attachedReader struct {
+	*io.PipeReader
+	conn net.Conn
+}
+
+func (r attachedReader) Close() error {
+	r.conn.Close()
+	return r.PipeReader.Close()
+}
+
+// dockerProcess stands in for the plugin process, signalling the container
+// through the API and waiting on the wait made for it before it started.
+type dockerProcess struct {
+	api  *dockerAPI
+	id   string
+	done chan struct{}
+	err  error
+}
+
+func (p *dockerProcess) Wait() (*os.ProcessState, error) {
+	<-p.done
+	return nil, p.err
+}
+
+func (p *dockerProcess) Kill() error {
+	return p.Signal(os.Kill)
+}
+
+func (p *dockerProcess) Signal(sig os.Signal) error {
+	name := "SIGKILL"
+	if sig == os.Interrupt {
+		name = "SIGINT"
+	}
+	status, err := p.api.call(fmt.Sprintf("/containers/%s/kill?signal=%s", p.id, name), nil, nil)
+	if gone(status) {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dockerFrame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestDemuxDocker(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(dockerFrame(1, "rpc "))
+	in.Write(dockerFrame(2, "log line\n"))
+	in.Write(dockerFrame(1, "bytes"))
+	var stdout, stderr bytes.Buffer
+	if err := demuxDocker(&in, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "rpc bytes" || stderr.String() != "log line\n" {
+		t.Errorf("unexpected split %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRunPluginInDocker(t *testing.T) {
+	image := os.Getenv("PLUGIN_DOCKER_IMAGE")
+	if os.Getenv("DOCKER_HOST") == "" || image == "" {
+		t.Skip("set DOCKER_HOST and PLUGIN_DOCKER_IMAGE, an image of a plugin serving Test.Echo, to run")
+	}
+	client, err := RunPluginInDocker(image, DockerOptions{NetworkMode: "none", MemLimit: 64 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "container", &reply); err != nil || reply != "container" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+// fakeEngine serves enough of the Docker Engine API for one container,
+// running a Test plugin over the attach stream. The container exits with
+// code once its stdin is closed, and is then removed as AutoRemove does.
+type fakeEngine struct {
+	code    int
+	mu      sync.Mutex
+	calls   []string
+	removed chan struct{}
+	once    sync.Once
+}
+
+func (e *fakeEngine) record(call string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, call)
+}
+
+func (e *fakeEngine) remove() {
+	e.once.Do(func() { close(e.removed) })
+}
+
+func (e *fakeEngine) gone() bool {
+	select {
+	case <-e.removed:
+		return true
+	default:
+		return false
+	}
+}
+
+type frameWriter struct{ io.Writer }
+
+func (w frameWriter) Write(b []byte) (int, error) {
+	if _, err := w.Writer.Write(dockerFrame(1, string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := strings.TrimPrefix(r.URL.Path, "/containers/")
+	if call != "create" && e.gone() {
+		http.Error(w, "no such container", http.StatusNotFound)
+		return
+	}
+	e.record(call)
+	switch call {
+	case "create":
+		fmt.Fprint(w, `{"Id":"c1"}`)
+	case "c1/wait":
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-e.removed
+		fmt.Fprintf(w, `{"StatusCode":%d}`, e.code)
+	case "c1/attach":
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+		go func() {
+			srv := rpc.NewServer()
+			srv.RegisterName("Test", newTestAPI())
+			srv.ServeConn(struct {
+				io.Reader
+				io.Writer
+				io.Closer
+			}{rw.Reader, frameWriter{conn}, io.NopCloser(nil)})
+			e.remove()
+			conn.Close()
+		}()
+	case "c1/start", "c1/kill":
+		w.WriteHeader(http.StatusNoContent)
+	case "c1":
+		e.remove()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestDockerFakeEngine(t *testing.T) {
+	e := &fakeEngine{code: 3, removed: make(chan struct{})}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	d, err := newDockerAPI("tcp://" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe, err := d.run(DockerOptions{Image: "test"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(pipe, defaultMaxMessageSize)
+	var reply string
+	if err := client.Call("Test.Echo", "container", &reply); err != nil || reply != "container" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	client.Close()
+
+	var exit *AbnormalExitError
+	if err := pipe.Close(); !errors.As(err, &exit) || exit.Code != 3 {
+		t.Errorf("expected the exit code from the wait made before start, got %v", err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if got := strings.Join(e.calls[:3], " "); got != "create c1/wait c1/attach" {
+		t.Errorf("expected the wait before attach and start, got %q", got)
+	}
+	if err := pipe.WriteCloser.(halfCloser).SetDeadline(time.Now()); err == nil {
+		t.Error("expected the attach connection closed along with the pipe")
+	}
+}
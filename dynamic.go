@@ -0,0 +1,16 @@
+package plugin
+
+import "net/rpc"
+
+// CallJSON calls method with args and returns the reply as a map, for
+// plugins whose schema is only known at run time, such as ones written in
+// other languages. client must use the JSON codec, as from StartJSON, and
+// the plugin must serve it with ServeJSON or a compatible JSON-RPC server;
+// gob cannot carry untyped maps.
+func CallJSON(client *rpc.Client, method string, args map[string]interface{}) (map[string]interface{}, error) {
+	var reply map[string]interface{}
+	if err := client.Call(method, args, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"net"
+	"net/rpc/jsonrpc"
+	"testing"
+)
+
+type Operands struct {
+	A, B int
+}
+
+type mathAPI struct{}
+
+func (mathAPI) Add(args Operands, reply *map[string]int) error {
+	*reply = map[string]int{"sum": args.A + args.B}
+	return nil
+}
+
+func TestCallJSON(t *testing.T) {
+	host, conn := net.Pipe()
+	go NewFromConn("Math", conn, mathAPI{}).ServeJSON()
+	client := jsonrpc.NewClient(host)
+	defer client.Close()
+
+	reply, err := CallJSON(client, "Math.Add", map[string]interface{}{"A": 2, "B": 3})
+	if err != nil || reply["sum"] != float64(5) {
+		t.Errorf("unexpected reply %v, %v", reply, err)
+	}
+}
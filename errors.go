@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"fmt"
+	"net/rpc"
+	"os"
+	"strings"
+)
+
+// TransportError reports that the connection to the plugin failed, most
+// often because the process died. Calls failing this way may be retried.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return "plugin transport: " + e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError reports that a request or reply could not be encoded or
+// decoded, or named a service or method the plugin does not have.
+type ProtocolError struct {
+	Err error
+}
+
+func (e *ProtocolError) Error() string {
+	return "plugin protocol: " + e.Err.Error()
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// StartRetryError reports that a plugin configured with WithStartRetry
+// could not be launched in Attempts tries. Err is the last launch error, or
+// the context's error if the retries were cancelled.
+type StartRetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *StartRetryError) Error() string {
+	return fmt.Sprintf("plugin start failed after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *StartRetryError) Unwrap() error {
+	return e.Err
+}
+
+// AbnormalExitError is returned by Close when the plugin process exited
+// with a non-zero code or was killed by a signal, even if the shutdown was
+// otherwise orderly. Code is -1 when the process was signalled. The
+// interrupt sent by Close itself does not count as abnormal.
+type AbnormalExitError struct {
+	Code   int
+	Signal os.Signal
+}
+
+func (e *AbnormalExitError) Error() string {
+	if e.Panicked() {
+		return "plugin panicked"
+	}
+	if e.Signal != nil {
+		return "plugin exited abnormally: killed by signal " + e.Signal.String()
+	}
+	return fmt.Sprintf("plugin exited abnormally: exit code %d", e.Code)
+}
+
+// Panicked reports whether the plugin exited with PanicExitCode, as plugins
+// run under Main do when they panic.
+func (e *AbnormalExitError) Panicked() bool {
+	return e.Code == PanicExitCode
+}
+
+func abnormalExit(state *os.ProcessState) error {
+	if state == nil {
+		return nil
+	}
+	if sig := exitSignal(state); sig != nil {
+		if sig != os.Interrupt {
+			return &AbnormalExitError{Code: -1, Signal: sig}
+		}
+		return nil
+	}
+	if code := state.ExitCode(); code != 0 {
+		return &AbnormalExitError{Code: code}
+	}
+	return nil
+}
+
+// classifyError wraps err from an rpc.Client call in TransportError or
+// ProtocolError. Errors returned by the plugin's own methods arrive as
+// rpc.ServerError and are passed through unchanged, except a draining
+// plugin's refusal, which is returned as ShuttingDownError, a call over a
+// rate limit, returned as RateLimitedError, and a panic in an instrumented
+// method, which is returned as a PanicError.
+func classifyError(err error) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case rpc.ServerError:
+		if string(e) == ShuttingDownError.Error() {
+			return ShuttingDownError
+		}
+		if string(e) == RateLimitedError.Error() {
+			return RateLimitedError
+		}
+		if string(e) == MessageTooLargeError.Error() {
+			return &ProtocolError{MessageTooLargeError}
+		}
+		if perr, ok := parsePanicError(string(e)); ok {
+			return perr
+		}
+		if strings.HasPrefix(string(e), "rpc: ") || strings.HasPrefix(string(e), "gob: ") {
+			return &ProtocolError{err}
+		}
+		return err
+	case *TransportError, *ProtocolError:
+		return err
+	}
+	switch {
+	case err == ClientClosedError, err == MethodTimeoutError:
+		return err
+	case strings.HasSuffix(err.Error(), MessageTooLargeError.Error()):
+		return &ProtocolError{MessageTooLargeError}
+	case err == PluginClosedError, strings.HasSuffix(err.Error(), PluginClosedError.Error()):
+		return &TransportError{PluginClosedError}
+	case strings.HasPrefix(err.Error(), "reading body"),
+		strings.HasPrefix(err.Error(), "reading error body"),
+		strings.HasPrefix(err.Error(), "gob: "):
+		return &ProtocolError{err}
+	}
+	return &TransportError{err}
+}
@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	var transport *TransportError
+	var protocol *ProtocolError
+
+	if err := classifyError(io.ErrUnexpectedEOF); !errors.As(err, &transport) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected TransportError wrapping EOF, got %#v", err)
+	}
+	if err := classifyError(rpc.ErrShutdown); !errors.As(err, &transport) {
+		t.Errorf("expected TransportError for shutdown, got %#v", err)
+	}
+	if err := classifyError(errors.New("reading body gob: type mismatch")); !errors.As(err, &protocol) {
+		t.Errorf("expected ProtocolError for decode failure, got %#v", err)
+	}
+	if err := classifyError(rpc.ServerError("rpc: can't find method Test.Nope")); !errors.As(err, &protocol) {
+		t.Errorf("expected ProtocolError for unknown method, got %#v", err)
+	}
+	app := rpc.ServerError("no such widget")
+	if err := classifyError(app); err != app {
+		t.Errorf("expected application error passed through, got %#v", err)
+	}
+}
+
+func TestClientClassifiesErrors(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call("Test.Nope", "", new(string)); !errors.As(err, new(*ProtocolError)) {
+		t.Errorf("expected ProtocolError for unknown method, got %#v", err)
+	}
+}
+
+func TestPluginClosedMidCall(t *testing.T) {
+	var frame bytes.Buffer
+	enc := gob.NewEncoder(&frame)
+	enc.Encode(&rpc.Response{ServiceMethod: "Test.Echo", Seq: 0})
+	header := frame.Len()
+	enc.Encode("truncated reply")
+	frame.Truncate(header + 5)
+
+	r, w := io.Pipe()
+	client := newClient(readWriteCloser{r, io.Discard, r}, defaultMaxMessageSize)
+	defer client.Close()
+	var first, second string
+	truncated := client.Go("Test.Echo", "a", &first, nil)
+	pending := client.Go("Test.Echo", "b", &second, nil)
+	w.Write(frame.Bytes())
+	w.Close()
+
+	<-truncated.Done
+	if err := classifyError(truncated.Error); !errors.Is(err, PluginClosedError) {
+		t.Errorf("expected PluginClosedError for the truncated reply, got %v", err)
+	}
+	select {
+	case <-pending.Done:
+		if pending.Error != PluginClosedError {
+			t.Errorf("expected PluginClosedError for the pending call, got %v", pending.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending call hung after the plugin closed")
+	}
+}
+
+type unkillableProc struct {
+	*fakeProc
+}
+
+func (unkillableProc) Signal(os.Signal) error {
+	return nil
+}
+
+func (unkillableProc) Kill() error {
+	return os.ErrPermission
+}
+
+func TestXrrorWrapping(t *testing.T) {
+	proc := unkillableProc{newFakeProc()}
+	defer proc.fakeProc.Kill()
+	err := stopProc(proc, new(exitStatus), 10*time.Millisecond, killWaitTimeout, nil)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected the kill failure to wrap its cause, got %v", err)
+	}
+
+	a, b := ServiceNotFoundError("A"), ServiceNotFoundError("B")
+	if a.Error() == b.Error() || errors.Unwrap(a) != nil {
+		t.Errorf("expected independent errors without a cause, got %q and %q", a, b)
+	}
+}
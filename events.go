@@ -0,0 +1,56 @@
+package plugin
+
+import "time"
+
+type EventType int
+
+const (
+	EventStarted EventType = iota
+	EventRestarted
+	EventHandshake
+	EventStopped
+	EventHandover
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStarted:
+		return "started"
+	case EventRestarted:
+		return "restarted"
+	case EventHandshake:
+		return "handshake"
+	case EventStopped:
+		return "stopped"
+	case EventHandover:
+		return "handover"
+	}
+	return "unknown"
+}
+
+// Event is a lifecycle transition of a managed plugin. Err is set when the
+// transition failed, such as a launch or handshake error, and
+// Instance is the plugin client's instance ID, if it was given one.
+type Event struct {
+	Time     time.Time
+	Plugin   string
+	Instance string
+	Type     EventType
+	Err      error
+}
+
+var eventBuffer = 64
+
+func (m *Manager) emit(name, instance string, typ EventType, err error) {
+	select {
+	case m.events <- Event{time.Now(), name, instance, typ, err}:
+	default:
+	}
+}
+
+// Events returns the channel on which lifecycle events of managed plugins
+// are delivered. It is buffered, and events that arrive while the buffer is
+// full are dropped rather than holding up the plugins.
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
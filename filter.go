@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// WithStderrFilter passes only the lines of the plugin's standard error
+// for which fn returns true on to the WithStderr writer. fn is given each
+// line without its newline, and every line passed is written to the
+// writer in a single Write. Lines longer than maxFilterLine are cut into
+// pieces of that size, each filtered as a line of its own. The filter
+// applies wherever standard error goes to a writer: WithStderr,
+// WithStderrInherit, and a Stderr set by the WithCommandFunc function.
+// CaptureStderrPipe hands over the pipe itself, unfiltered.
+func WithStderrFilter(fn func(line string) bool) Option {
+	return func(c *config) {
+		c.stderrFilter = fn
+	}
+}
+
+// maxFilterLine bounds what a lineFilter holds waiting for a newline.
+const maxFilterLine = 64 << 10
+
+// lineFilter buffers what is written to it into lines, writing those fn
+// passes to w. A final line without a newline is held until Flush, or
+// until it reaches maxFilterLine.
+type lineFilter struct {
+	w  io.Writer
+	fn func(string) bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := f.buf[:i+1]
+		f.buf = f.buf[i+1:]
+		if err := f.pass(line); err != nil {
+			return len(p), err
+		}
+	}
+	for len(f.buf) >= maxFilterLine {
+		line := f.buf[:maxFilterLine]
+		f.buf = f.buf[maxFilterLine:]
+		if err := f.pass(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out a final line that had no newline, and flushes w.
+func (f *lineFilter) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := f.buf
+	f.buf = nil
+	var err error
+	if len(line) > 0 {
+		err = f.pass(line)
+	}
+	flush(f.w)
+	return err
+}
+
+func (f *lineFilter) pass(line []byte) error {
+	if !f.fn(string(bytes.TrimSuffix(line, []byte("\n")))) {
+		return nil
+	}
+	_, err := f.w.Write(line)
+	return err
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestStderrFilter(t *testing.T) {
+	var out bytes.Buffer
+	cmd, err := newConfig([]Option{
+		WithStderrFilter(func(line string) bool { return strings.Contains(line, "ERROR") }),
+		WithStderr(&out),
+	}).command("plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := cmd.(execCmd).Stderr
+	for _, chunk := range []string{"INFO starting\nERR", "OR disk full\nDEBUG tick\nDEBUG", " tock\nERROR no newline"} {
+		w.Write([]byte(chunk))
+	}
+	flush(w)
+	if got, want := out.String(), "ERROR disk full\nERROR no newline"; got != want {
+		t.Errorf("expected only ERROR lines, got %q, want %q", got, want)
+	}
+}
+
+func TestStderrFilterLongLine(t *testing.T) {
+	var out bytes.Buffer
+	f := &lineFilter{w: &out, fn: func(line string) bool { return strings.HasPrefix(line, "x") }}
+	long := strings.Repeat("x", maxFilterLine+10)
+	f.Write([]byte(long))
+	if out.Len() != maxFilterLine || len(f.buf) != 10 {
+		t.Errorf("expected a full buffer passed on as a line, got %d written and %d held", out.Len(), len(f.buf))
+	}
+	f.Write([]byte("\n"))
+	if out.String() != long+"\n" {
+		t.Errorf("expected the rest of the line after its newline, got %d bytes", out.Len())
+	}
+}
+
+func TestStderrFilterCommandFunc(t *testing.T) {
+	var out bytes.Buffer
+	cmd, err := newConfig([]Option{
+		WithStderrFilter(func(line string) bool { return strings.Contains(line, "ERROR") }),
+		WithCommandFunc(func(path string, args []string) *exec.Cmd {
+			cmd := exec.Command(path, args...)
+			cmd.Stderr = &out
+			return cmd
+		}),
+	}).command("plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := cmd.(execCmd).Stderr
+	w.Write([]byte("INFO starting\nERROR disk full\n"))
+	if got := out.String(); got != "ERROR disk full\n" {
+		t.Errorf("expected the filter on the command's own writer, got %q", got)
+	}
+}
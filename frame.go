@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// MaxFrameSize is the largest payload a Framer puts in one frame. Longer
+// writes are split across frames; a frame announcing a larger payload is
+// treated as corruption, failing every stream with FrameTooLargeError.
+const MaxFrameSize = 1 << 20
+
+const frameHeaderSize = 5
+
+// maxStreamBuffer is how many unread bytes a Framer buffers for one stream
+// before failing it with StreamOverflowError.
+const maxStreamBuffer = 16 * MaxFrameSize
+
+var (
+	FrameTooLargeError  = Xrror("plugin frame of %d bytes exceeds the maximum frame size").Out
+	StreamOverflowError = Xrror("plugin frame stream %d has more than %d unread bytes").Out
+)
+
+// Framer multiplexes independent byte streams over one connection, so that
+// for instance an RPC server and client can share a plugin's stdio pipes.
+// Each frame is a one byte stream id and a four byte big-endian payload
+// length followed by the payload. Frames are routed to the stream with their
+// id, and buffered until it is read, so a slow stream never holds up others.
+// A stream left unread fails with StreamOverflowError once maxStreamBuffer
+// bytes are waiting, and its later frames are dropped, as are frames for
+// closed streams and for ids that have not been opened.
+type Framer struct {
+	rwc io.ReadWriteCloser
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[byte]*frameStream
+	err     error
+}
+
+// NewFramer frames rwc, opening the streams with ids before any frame is
+// read so that none of their frames are dropped.
+func NewFramer(rwc io.ReadWriteCloser, ids ...byte) *Framer {
+	f := &Framer{rwc: rwc, streams: make(map[byte]*frameStream)}
+	for _, id := range ids {
+		f.stream(id)
+	}
+	go f.demux()
+	return f
+}
+
+// Stream returns the stream with id, opening it if need be. Both ends of a
+// connection use the same id for the same stream.
+func (f *Framer) Stream(id byte) io.ReadWriteCloser {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stream(id)
+}
+
+func (f *Framer) stream(id byte) *frameStream {
+	s, ok := f.streams[id]
+	if !ok {
+		s = &frameStream{id: id, framer: f, err: f.err}
+		s.cond = sync.NewCond(&s.mu)
+		f.streams[id] = s
+	}
+	return s
+}
+
+// Close closes the underlying connection, ending every stream.
+func (f *Framer) Close() error {
+	return f.rwc.Close()
+}
+
+func (f *Framer) demux() {
+	header := make([]byte, frameHeaderSize)
+	var err error
+	for {
+		if _, err = io.ReadFull(f.rwc, header); err != nil {
+			break
+		}
+		n := binary.BigEndian.Uint32(header[1:])
+		if n > MaxFrameSize {
+			err = FrameTooLargeError(n)
+			f.rwc.Close()
+			break
+		}
+		payload := make([]byte, n)
+		if _, err = io.ReadFull(f.rwc, payload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			break
+		}
+		f.mu.Lock()
+		s, ok := f.streams[header[0]]
+		f.mu.Unlock()
+		if ok {
+			s.push(payload)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+	for _, s := range f.streams {
+		s.fail(err)
+	}
+}
+
+func (f *Framer) writeFrame(id byte, b []byte) error {
+	header := make([]byte, frameHeaderSize)
+	header[0] = id
+	binary.BigEndian.PutUint32(header[1:], uint32(len(b)))
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if _, err := f.rwc.Write(header); err != nil {
+		return err
+	}
+	_, err := f.rwc.Write(b)
+	return err
+}
+
+type frameStream struct {
+	id     byte
+	framer *Framer
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error
+	closed bool
+}
+
+func (s *frameStream) push(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.err != nil {
+		return
+	}
+	if s.buf.Len()+len(b) > maxStreamBuffer {
+		s.buf = bytes.Buffer{}
+		s.err = StreamOverflowError(s.id, maxStreamBuffer)
+	} else {
+		s.buf.Write(b)
+	}
+	s.cond.Broadcast()
+}
+
+func (s *frameStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+}
+
+func (s *frameStream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.buf.Len() == 0 && s.err == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if s.buf.Len() > 0 {
+		return s.buf.Read(b)
+	}
+	return 0, s.err
+}
+
+func (s *frameStream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n := len(b) - written
+		if n > MaxFrameSize {
+			n = MaxFrameSize
+		}
+		if err := s.framer.writeFrame(s.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close stops reads from the stream but leaves the connection and the other
+// streams open; the Framer itself closes the connection.
+func (s *frameStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.buf = bytes.Buffer{}
+	s.cond.Broadcast()
+	return nil
+}
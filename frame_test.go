@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+func TestFramer(t *testing.T) {
+	hostConn, pluginConn := net.Pipe()
+	host, plugin := NewFramer(hostConn, 0, 1), NewFramer(pluginConn, 0, 1)
+	defer host.Close()
+
+	// One RPC connection in each direction over the same pipe.
+	go newPlugin("Test", "", plugin.Stream(0), newTestAPI(), nil).Serve()
+	go newPlugin("Test", "", host.Stream(1), newTestAPI(), nil).Serve()
+
+	hostClient := rpc.NewClient(host.Stream(0))
+	pluginClient := rpc.NewClient(plugin.Stream(1))
+	big := string(make([]byte, MaxFrameSize+1))
+	for _, c := range []*rpc.Client{hostClient, pluginClient} {
+		var reply string
+		if err := c.Call("Test.Echo", big, &reply); err != nil || reply != big {
+			t.Errorf("unexpected reply of %d bytes, %v", len(reply), err)
+		}
+	}
+}
+
+func TestFramerOversize(t *testing.T) {
+	hostConn, pluginConn := net.Pipe()
+	stream := NewFramer(pluginConn).Stream(0)
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header[1:], MaxFrameSize+1)
+	go hostConn.Write(header)
+
+	_, err := io.ReadAll(stream)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum frame size") {
+		t.Errorf("expected FrameTooLargeError, got %v", err)
+	}
+}
+
+func TestFramerFlood(t *testing.T) {
+	hostConn, pluginConn := net.Pipe()
+	host, peer := NewFramer(hostConn, 0, 1, 2), NewFramer(pluginConn)
+	defer host.Close()
+	host.Stream(2).Close()
+
+	// The peer floods the unread stream 1, the closed stream 2 and stream 9,
+	// which the host never opened, then writes to stream 0.
+	go func() {
+		chunk := make([]byte, MaxFrameSize)
+		for i := 0; i <= maxStreamBuffer/MaxFrameSize; i++ {
+			for _, id := range []byte{1, 2, 9} {
+				peer.Stream(id).Write(chunk)
+			}
+		}
+		peer.Stream(0).Write([]byte("ok"))
+	}()
+
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(host.Stream(0), b); err != nil || string(b) != "ok" {
+		t.Fatalf("expected stream 0 to read ok, got %q, %v", b, err)
+	}
+	if _, err := host.Stream(1).Read(b); err == nil || !strings.Contains(err.Error(), "unread bytes") {
+		t.Errorf("expected StreamOverflowError, got %v", err)
+	}
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	if _, ok := host.streams[9]; ok {
+		t.Error("expected no stream for an id nobody opened")
+	}
+	for id, s := range host.streams {
+		s.mu.Lock()
+		n := s.buf.Len()
+		s.mu.Unlock()
+		if n > maxStreamBuffer {
+			t.Errorf("stream %d buffered %d bytes", id, n)
+		}
+	}
+}
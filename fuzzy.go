@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"time"
+)
+
+// fuzzyProbeTimeout bounds how long FuzzyStart waits on each codec's probe
+// call before trying the next.
+var fuzzyProbeTimeout = 2 * time.Second
+
+var CodecNotDetectedError = Xrror("plugin %s speaks none of gob, JSON-RPC or framed gob").Out
+
+// fuzzyCodecs are the codecs FuzzyStart tries, in order.
+var fuzzyCodecs = []func(io.ReadWriteCloser, int) *rpc.Client{
+	newClient,
+	newJSONClient,
+	func(conn io.ReadWriteCloser, maxMessage int) *rpc.Client {
+		framer := NewFramer(conn, hostCallStream)
+		return newClient(framerConn{framer.Stream(hostCallStream), framer}, maxMessage)
+	},
+}
+
+// FuzzyStart is Start for a plugin binary whose codec is not known. It
+// tries gob, as served by Serve, then JSON-RPC, as served by ServeJSON, then
+// gob framed as by NewBiDiPlugin, launching the plugin afresh for each and
+// returning a client for the first that answers a Ping within a
+// couple of seconds. Plugins that answer none fail with CodecNotDetectedError.
+func FuzzyStart(output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	for _, newCodecClient := range fuzzyCodecs {
+		pipe, err := start(makeCommand(output, path, args))
+		if err != nil {
+			return nil, err
+		}
+		client := newCodecClient(pipe, defaultMaxMessageSize)
+		if probe(client, fuzzyProbeTimeout) {
+			return client, nil
+		}
+		client.Close()
+	}
+	return nil, CodecNotDetectedError(path)
+}
+
+// probe reports whether the plugin behind client answers a Ping, even if
+// only to say it was not created WithPingService.
+func probe(client *rpc.Client, timeout time.Duration) bool {
+	err := Ping(client, timeout)
+	_, ok := err.(rpc.ServerError)
+	return err == nil || ok
+}
@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFuzzyStart(t *testing.T) {
+	for _, mode := range []string{"echo", "json", "bidi"} {
+		path, args := helperProcess(t, mode)
+		client, err := FuzzyStart(os.Stderr, path, args...)
+		if err != nil {
+			t.Errorf("%s: %s", mode, err)
+			continue
+		}
+		var reply string
+		if err := client.Call("Test.Echo", mode, &reply); err != nil || reply != mode {
+			t.Errorf("%s: unexpected result %q, %v", mode, reply, err)
+		}
+		client.Close()
+	}
+}
+
+func TestFuzzyStartUnknown(t *testing.T) {
+	defer func(d time.Duration) { fuzzyProbeTimeout = d }(fuzzyProbeTimeout)
+	fuzzyProbeTimeout = 50 * time.Millisecond
+	path, args := helperProcess(t, "exit3")
+	if _, err := FuzzyStart(os.Stderr, path, args...); err == nil {
+		t.Error("expected a plugin that answers nothing to fail")
+	}
+}
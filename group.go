@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+type PluginConfig struct {
+	Name   string
+	Path   string
+	Args   []string
+	Output io.Writer
+	// MaxMessageSize limits the replies the host reads, as
+	// WithMaxMessageSize: 0 for the default, negative for no limit.
+	MaxMessageSize int
+}
+
+func (c PluginConfig) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Path
+}
+
+// StartGroupError holds the launch error of each plugin in a group, indexed
+// as the configs passed to StartGroup, with nil for those that started.
+type StartGroupError struct {
+	Configs []PluginConfig
+	Errs    []error
+}
+
+func (e *StartGroupError) Error() string {
+	var msgs []string
+	for i, err := range e.Errs {
+		if err != nil {
+			msgs = append(msgs, e.Configs[i].label()+": "+err.Error())
+		}
+	}
+	return "failed to start plugin group: " + strings.Join(msgs, "; ")
+}
+
+// StartGroup launches every plugin in cfgs concurrently. Either all start
+// and a client is returned for each, in order, or the ones that did start
+// are shut down again and a *StartGroupError is returned.
+func StartGroup(cfgs []PluginConfig) ([]*rpc.Client, error) {
+	pipes := make([]*ioPipe, len(cfgs))
+	errs := make([]error, len(cfgs))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		wg.Add(1)
+		go func(i int, cfg PluginConfig) {
+			defer wg.Done()
+			pipes[i], errs[i] = start(makeCommand(cfg.Output, cfg.Path, cfg.Args))
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	failed := false
+	for _, err := range errs {
+		failed = failed || err != nil
+	}
+	if failed {
+		for i, err := range errs {
+			if err == nil {
+				pipes[i].Close()
+			}
+		}
+		return nil, &StartGroupError{cfgs, errs}
+	}
+
+	clients := make([]*rpc.Client, len(pipes))
+	for i, pipe := range pipes {
+		clients[i] = newClient(pipe, (&config{maxMessageSize: cfgs[i].MaxMessageSize}).messageLimit())
+	}
+	return clients, nil
+}
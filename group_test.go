@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestStartGroupCleansUp(t *testing.T) {
+	var mu sync.Mutex
+	var started []*fakeCmd
+	launchErr := errors.New("no such plugin")
+	orig := makeCommand
+	makeCommand = func(_ io.Writer, path string, _ []string) commander {
+		cmd := &fakeCmd{api: newTestAPI(), launches: new(int32)}
+		if path == "missing" {
+			cmd.startErr = launchErr
+		}
+		mu.Lock()
+		started = append(started, cmd)
+		mu.Unlock()
+		return cmd
+	}
+	defer func() { makeCommand = orig }()
+
+	clients, err := StartGroup([]PluginConfig{
+		{Name: "a", Path: "ok"},
+		{Name: "b", Path: "missing"},
+		{Name: "c", Path: "ok"},
+	})
+	var groupErr *StartGroupError
+	if clients != nil || !errors.As(err, &groupErr) || groupErr.Errs[1] != launchErr {
+		t.Fatalf("expected StartGroupError for b, got %v", err)
+	}
+	for _, cmd := range started {
+		if cmd.proc == nil {
+			continue
+		}
+		select {
+		case <-cmd.proc.done:
+		default:
+			t.Error("expected started plugin to be stopped after group failure")
+		}
+	}
+}
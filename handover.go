@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+	"time"
+)
+
+// handoverDrainTimeout bounds how long HandoverTo waits for calls on the
+// old plugin before closing it regardless.
+var handoverDrainTimeout = 30 * time.Second
+
+// HandoverTo replaces the running plugin with one started from newPath,
+// with the same options, without failing any call. The new plugin is
+// launched and handshaken while the old one keeps serving; if that fails
+// the error is returned and nothing changes. Otherwise calls made from then
+// on go to the new plugin, and the old one is closed once the calls it was
+// already serving have returned, and its Close error is returned. Either
+// way an EventHandover is emitted with the error. Later relaunches also use
+// newPath.
+func (c *Client) HandoverTo(newPath string) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ClientClosedError
+	}
+	next := &Client{path: newPath, conf: c.conf, launched: true, notify: c.notify}
+	c.mu.Unlock()
+
+	next.mu.Lock()
+	err := next.launch(context.Background())
+	if next.idle != nil {
+		next.idle.Stop()
+	}
+	next.mu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		c.emit(EventHandover, err)
+		c.mu.Unlock()
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		next.rpc.Close()
+		return ClientClosedError
+	}
+	old := c.rpc
+	c.path = newPath
+	c.rpc, c.conn, c.pipe, c.exit, c.manifest = next.rpc, next.conn, next.pipe, next.exit, next.manifest
+	c.armIdle()
+	drained := c.retire(old)
+	c.mu.Unlock()
+
+	if old != nil {
+		select {
+		case <-drained:
+		case <-time.After(handoverDrainTimeout):
+		}
+		err = old.Close()
+	}
+	c.mu.Lock()
+	c.emit(EventHandover, err)
+	c.mu.Unlock()
+	return err
+}
+
+// retire returns a channel closed once no calls are using client.
+func (c *Client) retire(client *rpc.Client) <-chan struct{} {
+	done := make(chan struct{})
+	if c.using[client] == 0 {
+		close(done)
+		return done
+	}
+	if c.retired == nil {
+		c.retired = make(map[*rpc.Client]chan struct{})
+	}
+	c.retired[client] = done
+	return done
+}
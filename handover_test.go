@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"os"
+	"testing"
+)
+
+func TestHandoverTo(t *testing.T) {
+	old := newTestAPI()
+	orig := makeCommand
+	makeCommand = func(_ io.Writer, path string, _ []string) commander {
+		switch path {
+		case "old":
+			return &fakeCmd{api: old}
+		case "new":
+			return &fakeCmd{api: shoutAPI{}}
+		}
+		return &fakeCmd{startErr: os.ErrNotExist}
+	}
+	defer func() { makeCommand = orig }()
+
+	c, err := NewClient("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	events := make(chan error, 3)
+	c.setNotify(func(typ EventType, err error) {
+		if typ == EventHandover {
+			events <- err
+		}
+	})
+
+	if err := c.HandoverTo("missing"); err == nil {
+		t.Fatal("expected a failed launch to abort the handover")
+	}
+	if err := <-events; err == nil {
+		t.Error("expected a failed handover to be emitted with its error")
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "still old", &reply); err != nil || reply != "still old" {
+		t.Fatalf("expected the old plugin to keep serving, got %q, %v", reply, err)
+	}
+
+	var slow string
+	inflight := make(chan error, 1)
+	go func() { inflight <- c.Call("Test.Slow", "in flight", &slow) }()
+	<-old.started
+	handedOver := make(chan error, 1)
+	go func() { handedOver <- c.HandoverTo("new") }()
+
+	// New calls reach the new plugin while the old one is still busy.
+	for reply != "NEW" {
+		if err := c.Call("Test.Echo", "new", &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-handedOver:
+		t.Fatalf("expected the handover to wait for the old plugin's call, got %v", err)
+	default:
+	}
+	close(old.release)
+	if err := <-inflight; err != nil || slow != "in flight" {
+		t.Errorf("expected the in-flight call to finish on the old plugin, got %q, %v", slow, err)
+	}
+	if err := <-handedOver; err != nil {
+		t.Errorf("unexpected handover error: %s", err)
+	}
+	if err := <-events; err != nil {
+		t.Errorf("expected the handover to be emitted without an error, got %v", err)
+	}
+
+	// The old plugin's Close error is returned and emitted.
+	c.mu.Lock()
+	c.rpc.Close()
+	c.mu.Unlock()
+	if err := c.HandoverTo("old"); err != rpc.ErrShutdown {
+		t.Errorf("expected the old client's Close error, got %v", err)
+	}
+	if err := <-events; err != rpc.ErrShutdown {
+		t.Errorf("expected the handover to be emitted with the Close error, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	handshakeEnv     = "PLUGIN_HANDSHAKE"
+	handshakeMagic   = "PLUGIN"
+	handshakeVersion = 1
+)
+
+var (
+	handshakeTimeout      = 5 * time.Second
+	HandshakeTimeoutError = Xrror("timed out waiting for plugin handshake")
+	HandshakeError        = Xrror("malformed plugin handshake: %q").Out
+	HandshakeVersionError = Xrror("plugin handshake version %d, expected %d").Out
+	StdoutPollutedError   = Xrror("plugin wrote %q to stdout before the handshake; plugin output must go to stderr or Plugin.Stdout").Out
+)
+
+const maxPollution = 256
+
+type hello struct {
+	Version  int       `json:"version"`
+	Compress bool      `json:"compress,omitempty"`
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+func handshakeEnvVar() string {
+	return handshakeEnv + "=" + strconv.Itoa(handshakeVersion)
+}
+
+func writeHello(w io.Writer, h hello) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	line := append([]byte(handshakeMagic+" "), b...)
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readHello reads a byte at a time so nothing past the handshake line is
+// consumed from r before the codec takes over. The magic prefix is checked
+// as it arrives, so output a plugin printed to stdout ahead of its hello is
+// reported as such, up to the end of its line, rather than read as a
+// malformed handshake.
+func readHello(r io.Reader) (hello, error) {
+	var line []byte
+	prefix := []byte(handshakeMagic + " ")
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return hello{}, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+		if n := len(line); n <= len(prefix) && line[n-1] != prefix[n-1] {
+			return hello{}, StdoutPollutedError(drainLine(r, line))
+		}
+	}
+	var h hello
+	if !bytes.HasPrefix(line, prefix) || json.Unmarshal(line[len(prefix):], &h) != nil {
+		return hello{}, HandshakeError(line)
+	}
+	if h.Version != handshakeVersion {
+		return hello{}, HandshakeVersionError(h.Version, handshakeVersion)
+	}
+	return h, nil
+}
+
+// drainLine reads the rest of the line started by line from r, up to
+// maxPollution bytes in all, for reporting.
+func drainLine(r io.Reader, line []byte) []byte {
+	b := make([]byte, 1)
+	for len(line) < maxPollution {
+		if _, err := io.ReadFull(r, b); err != nil || b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	return line
+}
+
+// handshake exchanges hello lines over rw, each side writing its own before
+// reading the peer's, and returns rw wrapped for whatever both sides agreed
+// along with the peer's manifest. Only plugins send a manifest.
+func handshake(rw io.ReadWriteCloser, conf *config, manifest *Manifest) (io.ReadWriteCloser, *Manifest, error) {
+	local := hello{Version: handshakeVersion, Compress: conf.compress, Manifest: manifest}
+	written := make(chan error, 1)
+	go func() { written <- writeHello(rw, local) }()
+	remote, err := readHello(rw)
+	if writeErr := <-written; err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if local.Compress && remote.Compress {
+		conn, err := newFlateConn(rw, conf.compressLevel)
+		return conn, remote.Manifest, err
+	}
+	return rw, remote.Manifest, nil
+}
+
+type flateConn struct {
+	io.Reader
+	w   *flate.Writer
+	rwc io.ReadWriteCloser
+}
+
+func newFlateConn(rwc io.ReadWriteCloser, level int) (io.ReadWriteCloser, error) {
+	w, err := flate.NewWriter(rwc, level)
+	if err != nil {
+		return nil, err
+	}
+	return &flateConn{flate.NewReader(rwc), w, rwc}, nil
+}
+
+func (f *flateConn) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, f.w.Flush()
+}
+
+func (f *flateConn) Close() error {
+	err := f.w.Close()
+	if closeErr := f.rwc.Close(); closeErr != nil {
+		err = closeErr
+	}
+	return err
+}
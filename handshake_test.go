@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"compress/flate"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type countingConn struct {
+	io.ReadWriteCloser
+	written *int64
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(c.written, int64(len(b)))
+	return c.ReadWriteCloser.Write(b)
+}
+
+func handshakePair(t testing.TB, host, plugin []Option) (*rpc.Client, *int64) {
+	hostEnd, pluginEnd := net.Pipe()
+	written := new(int64)
+
+	p := NewFromConn("Test", pluginEnd, newTestAPI(), plugin...)
+	p.handshake = true
+	go p.Serve()
+
+	conn, _, err := handshake(countingConn{hostEnd, written}, newConfig(host), nil)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	return rpc.NewClient(conn), written
+}
+
+func echoBytes(t testing.TB, host, plugin []Option, payload string) int64 {
+	client, written := handshakePair(t, host, plugin)
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", payload, &reply); err != nil || reply != payload {
+		t.Fatalf("echo failed: %v", err)
+	}
+	return atomic.LoadInt64(written)
+}
+
+func TestCompression(t *testing.T) {
+	payload := strings.Repeat("plugin payload ", 4096)
+	compress := []Option{WithCompression(flate.BestSpeed)}
+
+	plain := echoBytes(t, nil, nil, payload)
+	if n := echoBytes(t, compress, nil, payload); n < plain/2 {
+		t.Errorf("one-sided compression should not be negotiated, wrote %d of %d", n, plain)
+	}
+	if n := echoBytes(t, compress, compress, payload); n >= plain/4 {
+		t.Errorf("expected compressed stream well under %d bytes, wrote %d", plain, n)
+	}
+}
+
+func benchmarkCompression(b *testing.B, opts []Option) {
+	payload := strings.Repeat("plugin payload ", 4096)
+	client, written := handshakePair(b, opts, opts)
+	defer client.Close()
+	b.ResetTimer()
+	var reply string
+	for i := 0; i < b.N; i++ {
+		if err := client.Call("Test.Echo", payload, &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(written))/float64(b.N), "wire-B/op")
+}
+
+func BenchmarkCompressionNone(b *testing.B) { benchmarkCompression(b, nil) }
+
+func BenchmarkCompressionBestSpeed(b *testing.B) {
+	benchmarkCompression(b, []Option{WithCompression(flate.BestSpeed)})
+}
+
+func BenchmarkCompressionBest(b *testing.B) {
+	benchmarkCompression(b, []Option{WithCompression(flate.BestCompression)})
+}
+
+func TestHandshakeStdoutPolluted(t *testing.T) {
+	r := strings.NewReader("Welcome to the plugin!\nPLUGIN {\"version\":1}\n")
+	_, err := readHello(r)
+	if err == nil || !strings.Contains(err.Error(), `"Welcome to the plugin!"`) || !strings.Contains(err.Error(), "stdout") {
+		t.Errorf("expected an error naming the stdout pollution, got %v", err)
+	}
+	if h, err := readHello(strings.NewReader("PLUGIN {\"version\":1}\n")); err != nil || h.Version != 1 {
+		t.Errorf("unexpected result for a clean handshake %+v, %v", h, err)
+	}
+}
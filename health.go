@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+type PluginHealthStatus int
+
+const (
+	Healthy PluginHealthStatus = iota
+	Degraded
+	Unhealthy
+)
+
+func (s PluginHealthStatus) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	}
+	return "unhealthy"
+}
+
+// HealthMonitor grades a plugin by how many of its last window calls
+// failed: Degraded at warnThreshold failures, Unhealthy at errorThreshold.
+// As with CircuitBreaker, errors returned by the plugin's own methods do
+// not count as failures.
+type HealthMonitor struct {
+	client         caller
+	warnThreshold  int
+	errorThreshold int
+
+	mu       sync.Mutex
+	outcomes []bool
+	next     int
+	failures int
+}
+
+// NewHealthMonitor returns a HealthMonitor making calls through client and
+// grading it over its last window calls. A threshold of 0 disables that
+// grade, so a monitor with both at 0 always reports Healthy.
+func NewHealthMonitor(client *rpc.Client, window, warnThreshold, errorThreshold int) *HealthMonitor {
+	return newHealthMonitor(client, window, warnThreshold, errorThreshold)
+}
+
+func newHealthMonitor(client caller, window, warnThreshold, errorThreshold int) *HealthMonitor {
+	if window < 1 {
+		window = 1
+	}
+	return &HealthMonitor{
+		client:         client,
+		warnThreshold:  warnThreshold,
+		errorThreshold: errorThreshold,
+		outcomes:       make([]bool, 0, window),
+	}
+}
+
+func (m *HealthMonitor) Call(serviceMethod string, args, reply interface{}) error {
+	err := m.client.Call(serviceMethod, args, reply)
+	m.Record(err)
+	return err
+}
+
+// Ping records the outcome of pinging the plugin with the package level
+// Ping, so an idle plugin is still graded. The monitor's client must be an
+// *rpc.Client serving PingService.
+func (m *HealthMonitor) Ping(timeout time.Duration) error {
+	client, ok := m.client.(*rpc.Client)
+	if !ok {
+		return UnsupportedMethodError(PingServiceName + ".Ping")
+	}
+	err := Ping(client, timeout)
+	m.Record(err)
+	return err
+}
+
+// Record adds the outcome of a call made outside the monitor to its window.
+func (m *HealthMonitor) Record(err error) {
+	_, ok := err.(rpc.ServerError)
+	failed := err != nil && !ok
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.outcomes) < cap(m.outcomes) {
+		m.outcomes = append(m.outcomes, failed)
+	} else {
+		if m.outcomes[m.next] {
+			m.failures--
+		}
+		m.outcomes[m.next] = failed
+		m.next = (m.next + 1) % len(m.outcomes)
+	}
+	if failed {
+		m.failures++
+	}
+}
+
+func (m *HealthMonitor) Status() PluginHealthStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.errorThreshold > 0 && m.failures >= m.errorThreshold:
+		return Unhealthy
+	case m.warnThreshold > 0 && m.failures >= m.warnThreshold:
+		return Degraded
+	}
+	return Healthy
+}
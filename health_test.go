@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	fake := &fakeCaller{}
+	m := newHealthMonitor(fake, 5, 2, 4)
+	for i, tc := range []struct {
+		err  error
+		want PluginHealthStatus
+	}{
+		{nil, Healthy},
+		{io.ErrUnexpectedEOF, Healthy},
+		{rpc.ServerError("application error"), Healthy},
+		{io.ErrUnexpectedEOF, Degraded},
+		{io.ErrUnexpectedEOF, Degraded},
+		{io.ErrUnexpectedEOF, Unhealthy},
+		// The first failure leaves the window.
+		{nil, Degraded},
+		{nil, Degraded},
+		{nil, Degraded},
+		{nil, Healthy},
+	} {
+		fake.err = tc.err
+		m.Call("Test.Echo", nil, nil)
+		if s := m.Status(); s != tc.want {
+			t.Fatalf("after call %d (%v): expected %s, got %s", i+1, tc.err, tc.want, s)
+		}
+	}
+}
+
+func TestHealthMonitorDisabledThreshold(t *testing.T) {
+	m := newHealthMonitor(&fakeCaller{}, 3, 0, 2)
+	if s := m.Status(); s != Healthy {
+		t.Errorf("expected a new monitor with no warn threshold to be healthy, got %s", s)
+	}
+	m.Record(io.EOF)
+	if s := m.Status(); s != Healthy {
+		t.Errorf("expected one failure to stay healthy without a warn threshold, got %s", s)
+	}
+	m.Record(io.EOF)
+	if s := m.Status(); s != Unhealthy {
+		t.Errorf("expected two failures to be unhealthy, got %s", s)
+	}
+	if s := newHealthMonitor(&fakeCaller{}, 3, 0, 0).Status(); s != Healthy {
+		t.Errorf("expected a monitor with both thresholds off to be healthy, got %s", s)
+	}
+}
+
+func TestHealthMonitorPing(t *testing.T) {
+	_, client := NewInProcess("Test", newTestAPI(), WithPingService())
+	m := NewHealthMonitor(client, 2, 1, 2)
+	if err := m.Ping(time.Second); err != nil || m.Status() != Healthy {
+		t.Fatalf("expected a healthy plugin, got %s, %v", m.Status(), err)
+	}
+	client.Close()
+	if err := m.Ping(time.Second); err == nil {
+		t.Fatal("expected a ping over a closed client to fail")
+	}
+	if s := m.Status(); s != Degraded {
+		t.Errorf("expected a failed ping to count, got %s", s)
+	}
+}
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const helperEnv = "PLUGIN_WANT_HELPER_PROCESS"
+
+// helperProcess returns the path and arguments that re-run the test binary
+// as a plugin in the given mode; see TestHelperProcess.
+func helperProcess(t *testing.T, mode string) (string, []string) {
+	t.Setenv(helperEnv, "1")
+	return os.Args[0], []string{"-test.run=^TestHelperProcess$", "--", mode}
+}
+
+type configAPI map[string]string
+
+func (c configAPI) Get(key string, reply *string) error {
+	*reply = c[key]
+	return nil
+}
+
+type seccompAPI struct{}
+
+func (seccompAPI) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func (seccompAPI) Getppid(args int, reply *int) error {
+	*reply = syscall.Getppid()
+	return nil
+}
+
+type uidAPI struct{}
+
+func (uidAPI) Geteuid(args int, reply *int) error {
+	*reply = os.Geteuid()
+	return nil
+}
+
+type childAPI struct {
+	pid int
+}
+
+func (c childAPI) Pid(args int, reply *int) error {
+	*reply = c.pid
+	return nil
+}
+
+type printAPI struct {
+	w io.Writer
+}
+
+func (a *printAPI) Echo(args string, reply *string) error {
+	fmt.Fprintln(a.w, "printed by plugin:", args)
+	*reply = args
+	return nil
+}
+
+type secretAPI struct{}
+
+func (secretAPI) Secret(key string, reply *string) error {
+	secret, err := ReadSecret(key)
+	*reply = secret
+	return err
+}
+
+func (secretAPI) Environ(args int, reply *string) error {
+	b, err := os.ReadFile("/proc/self/environ")
+	*reply = string(b)
+	return err
+}
+
+type progressAPI struct {
+	w io.Writer
+}
+
+func (p *progressAPI) Work(steps int, reply *int) error {
+	for i := 1; i <= steps; i++ {
+		fmt.Fprintf(p.w, "step %d of %d\n", i, steps)
+	}
+	*reply = steps
+	return nil
+}
+
+func (p *progressAPI) Flood(n int, reply *int) error {
+	*reply, _ = p.w.Write(bytes.Repeat([]byte("x"), n))
+	return nil
+}
+
+type instanceAPI struct {
+	id string
+}
+
+func (i *instanceAPI) ID(args int, reply *string) error {
+	*reply = i.id
+	return nil
+}
+
+type delayAPI struct{}
+
+func (delayAPI) Echo(args string, reply *string) error {
+	time.Sleep(50 * time.Millisecond)
+	*reply = args
+	return nil
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		return
+	}
+	defer os.Exit(0)
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		os.Exit(2)
+	}
+	switch args[1] {
+	case "echo":
+		New("Test", "", newTestAPI()).Serve()
+	case "seccomp":
+		New("Test", "", seccompAPI{}).Serve()
+	case "uid":
+		New("Test", "", uidAPI{}).Serve()
+	case "grandchild":
+		sleep := exec.Command("sleep", "60")
+		if err := sleep.Start(); err != nil {
+			os.Exit(3)
+		}
+		New("Test", "", childAPI{sleep.Process.Pid}).Serve()
+	case "print":
+		api := &printAPI{}
+		p := New("Test", "", api)
+		api.w = p.Stdout()
+		p.Serve()
+	case "json":
+		New("Test", "", newTestAPI()).ServeJSON()
+	case "stderr":
+		fmt.Fprintln(os.Stderr, "hello stderr")
+		New("Test", "", newTestAPI()).Serve()
+	case "shm":
+		New("Test", "", newTestAPI(), WithSharedMemory(args[2], 1<<16)).Serve()
+	case "delay":
+		New("Test", "", delayAPI{}).Serve()
+	case "bidi":
+		b, err := NewBiDiPlugin("Test", "", newTestAPI())
+		if err != nil {
+			os.Exit(3)
+		}
+		<-b.Done()
+	case "secret":
+		New("Test", "", secretAPI{}).Serve()
+	case "ready":
+		time.Sleep(50 * time.Millisecond)
+		p := New("Test", "", newTestAPI())
+		p.Ready()
+		p.Serve()
+	case "main":
+		Main(func() error { return New("Test", "", newTestAPI()).Serve() })
+	case "panic":
+		Main(func() error { panic("boom") })
+	case "progress":
+		api := &progressAPI{}
+		p := New("Test", "", api)
+		api.w = p.Stdout()
+		p.Serve()
+	case "instance":
+		api := &instanceAPI{}
+		p := New("Test", "", api)
+		api.id = p.InstanceID()
+		p.Serve()
+	case "mtls":
+		var pems [3][]byte
+		for i, name := range []string{"ca.pem", "cert.pem", "key.pem"} {
+			b, err := os.ReadFile(filepath.Join(args[2], name))
+			if err != nil {
+				os.Exit(3)
+			}
+			pems[i] = b
+		}
+		p, err := NewMTLS(pems[0], pems[1], pems[2], args[3], "Test", newTestAPI())
+		if err != nil {
+			os.Exit(3)
+		}
+		p.Serve()
+	case "stubborn":
+		signal.Ignore(os.Interrupt)
+		New("Test", "", newTestAPI()).Serve()
+		time.Sleep(time.Minute)
+	case "exit3":
+		os.Exit(3)
+	case "stdin":
+		p := New("Test", "", newTestAPI())
+		cfg := configAPI{}
+		if err := p.DecodeStdin(&cfg); err != nil {
+			os.Exit(3)
+		}
+		p.RegisterName("Config", cfg)
+		p.Serve()
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ExportHTTP registers a POST handler on mux at path/Service.Method for
+// every method registered on the plugin so far. The request body is decoded
+// as JSON into the method's argument and the reply is written back as JSON;
+// errors from the method are answered with 500 and the error text. The
+// package's own "__" services are never exported, as they are only for
+// the host.
+func (p *Plugin) ExportHTTP(mux *http.ServeMux, path string) {
+	path = strings.TrimSuffix(path, "/")
+	for _, name := range p.dispatch.methodNames() {
+		if service, _, _ := strings.Cut(name, "."); !reserved(service) {
+			mux.HandleFunc(path+"/"+name, p.httpHandler(name))
+		}
+	}
+}
+
+func (p *Plugin) httpHandler(serviceMethod string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, mt, err := p.dispatch.lookup(serviceMethod)
+		if err == nil && reserved(s.name) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		argv, replyv, err := decodeArgs(json.NewDecoder(r.Body).Decode, mt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !p.dispatch.begin() {
+			http.Error(w, ShuttingDownError.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		err = p.dispatch.invoke(s, mt, argv, replyv)
+		p.dispatch.end()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(replyv.Interface())
+	}
+}
+
+func (d *dispatcher) methodNames() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var names []string
+	for _, s := range d.services {
+		for name := range s.methods {
+			names = append(names, s.name+"."+name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportHTTP(t *testing.T) {
+	p, _ := pipePlugin(t, newTestAPI())
+	mux := http.NewServeMux()
+	p.ExportHTTP(mux, "/rpc/")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/rpc/Test.Echo", "application/json", strings.NewReader(`"curl"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var reply string
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil || resp.StatusCode != http.StatusOK || reply != "curl" {
+		t.Errorf("unexpected response %d %q, %v", resp.StatusCode, reply, err)
+	}
+
+	resp, err = http.Post(srv.URL+"/rpc/"+shutdownService+".Shutdown", "application/json", strings.NewReader(`0`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the reserved shutdown service not to be exported, got %d", resp.StatusCode)
+	}
+	resp, err = http.Post(srv.URL+"/rpc/Test.Echo", "application/json", strings.NewReader(`"after"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || reply != "after" {
+		t.Errorf("expected the plugin to keep serving, got %d %q, %v", resp.StatusCode, reply, err)
+	}
+	rec := httptest.NewRecorder()
+	p.httpHandler(shutdownService+".Shutdown")(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`0`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected the handler to refuse a reserved service, got %d", rec.Code)
+	}
+
+	resp, err = http.Get(srv.URL + "/rpc/Test.Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be refused, got %d", resp.StatusCode)
+	}
+}
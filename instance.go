@@ -0,0 +1,56 @@
+package plugin
+
+import "os"
+
+// instanceIDEnv passes the instance ID given with WithInstanceID to the
+// plugin process.
+const instanceIDEnv = "PLUGIN_INSTANCE_ID"
+
+var NoInstanceIDError = Xrror("plugin client has no instance ID")
+
+// WithInstanceID tells apart instances of the same plugin binary, such as
+// shards of one workload. The ID labels the client's events and is passed
+// to the plugin, which uses it in its log lines and can read it with
+// Plugin.InstanceID. Service names are unchanged, so every instance still
+// serves the same API.
+func WithInstanceID(id string) Option {
+	return func(c *config) {
+		c.instanceID = id
+	}
+}
+
+// InstanceID returns the ID the client was created with by WithInstanceID.
+func (c *Client) InstanceID() string {
+	return c.conf.instanceID
+}
+
+// InstanceID returns the ID the host gave this instance of the plugin with
+// WithInstanceID, or "" if it gave none.
+func (p *Plugin) InstanceID() string {
+	return p.instance
+}
+
+func (p *Plugin) setInstance() {
+	p.instance = p.conf.instanceID
+	if p.instance == "" {
+		p.instance = os.Getenv(instanceIDEnv)
+	}
+}
+
+// label names the plugin, with its instance ID if it has one, for logs.
+func (p *Plugin) label() string {
+	if p.instance == "" {
+		return p.name
+	}
+	return p.name + "[" + p.instance + "]"
+}
+
+// AddInstance adds c under its instance ID, so instances of one plugin
+// binary can be managed side by side.
+func (m *Manager) AddInstance(c *Client) error {
+	id := c.InstanceID()
+	if id == "" {
+		return NoInstanceIDError
+	}
+	return m.Add(id, c)
+}
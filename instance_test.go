@@ -0,0 +1,43 @@
+package plugin
+
+import "testing"
+
+func TestInstanceID(t *testing.T) {
+	path, args := helperProcess(t, "instance")
+	m := NewManager()
+	defer m.Shutdown()
+	for _, id := range []string{"shard-1", "shard-2"} {
+		c, err := NewClient(path, WithArgs(args...), WithInstanceID(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.AddInstance(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"shard-1", "shard-2"} {
+		c, ok := m.Get(id)
+		if !ok {
+			t.Fatalf("expected instance %s to be managed", id)
+		}
+		var got string
+		if err := c.Call("Test.ID", 0, &got); err != nil || got != id {
+			t.Errorf("expected the plugin to see instance ID %s, got %q, %v", id, got, err)
+		}
+		c.Reconnect()
+	}
+	events := 0
+	for len(m.Events()) > 0 {
+		e := <-m.Events()
+		if e.Instance == "" || e.Instance != e.Plugin {
+			t.Errorf("expected events labelled with their instance ID, got %+v", e)
+		}
+		events++
+	}
+	if events == 0 {
+		t.Error("expected events from reconnecting")
+	}
+	if err := m.AddInstance(StartLazy(path)); err != NoInstanceIDError {
+		t.Errorf("expected NoInstanceIDError, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+)
+
+var MethodPanicError = Xrror("plugin method panicked")
+
+// PanicError is returned for a call to an instrumented method that
+// panicked, to the host as well as in the plugin. It matches
+// MethodPanicError with errors.Is.
+type PanicError struct {
+	Method string
+	Value  string
+}
+
+func (e *PanicError) Error() string {
+	return MethodPanicError.Error() + ": " + e.Method + ": " + e.Value
+}
+
+func (e *PanicError) Unwrap() error {
+	return MethodPanicError
+}
+
+// panicMarker starts the message a PanicError is sent to the host as. No
+// ordinary error message starts with a NUL, so a method's own error that
+// happens to read like a panic is not mistaken for one.
+const panicMarker = "\x00panic\x00"
+
+// panicReply is a PanicError as the plugin returns it, spelled with
+// panicMarker for parsePanicError on the host.
+type panicReply struct {
+	*PanicError
+}
+
+func (e panicReply) Error() string {
+	return panicMarker + e.Method + "\x00" + e.Value
+}
+
+func (e panicReply) Unwrap() error {
+	return e.PanicError
+}
+
+// parsePanicError recovers the PanicError a plugin sent as msg.
+func parsePanicError(msg string) (*PanicError, bool) {
+	rest := strings.TrimPrefix(msg, panicMarker)
+	if rest == msg {
+		return nil, false
+	}
+	method, value, ok := strings.Cut(rest, "\x00")
+	if !ok {
+		return nil, false
+	}
+	return &PanicError{Method: method, Value: value}, true
+}
+
+// Instrument recovers panics in the methods of the service name, so a bug
+// in one method fails the call with a PanicError rather than crashing the
+// plugin. Each panic is logged with its stack trace through the default
+// slog logger. Like Use, Instrument must be called before Serve.
+func (p *Plugin) Instrument(name string) error {
+	if p.dispatch.receiver(name) == nil {
+		return ServiceNotFoundError(name)
+	}
+	prefix := name + "."
+	label := p.label()
+	p.Use(func(method string, handler func(args, reply interface{}) error) func(args, reply interface{}) error {
+		if !strings.HasPrefix(method, prefix) {
+			return handler
+		}
+		return func(args, reply interface{}) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					perr := &PanicError{Method: method, Value: fmt.Sprint(r)}
+					slog.Error(perr.Error(), "plugin", label, "method", method, "panic", perr.Value, "stack", string(debug.Stack()))
+					err = panicReply{perr}
+				}
+			}()
+			return handler(args, reply)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+type panickyAPI struct {
+	config *configAPI
+}
+
+func (a *panickyAPI) Lookup(key string, reply *string) error {
+	*reply = (*a.config)[key]
+	return nil
+}
+
+func (a *panickyAPI) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func (a *panickyAPI) Fail(msg string, reply *string) error {
+	return errors.New(msg)
+}
+
+func TestInstrument(t *testing.T) {
+	var logs bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(orig)
+
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, &panickyAPI{})
+	if err := p.Instrument("Test"); err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve()
+	c := NewClientFromConn(host)
+	defer c.Close()
+
+	var reply string
+	err := c.Call("Test.Lookup", "key", &reply)
+	var perr *PanicError
+	if !errors.Is(err, MethodPanicError) || !errors.As(err, &perr) {
+		t.Fatalf("expected a PanicError, got %#v", err)
+	}
+	if perr.Method != "Test.Lookup" || !strings.Contains(perr.Value, "nil pointer") {
+		t.Errorf("unexpected panic details %+v", perr)
+	}
+	if !strings.Contains(logs.String(), "method=Test.Lookup") || !strings.Contains(logs.String(), "instrument_test.go") {
+		t.Errorf("expected the panic to be logged with its stack, got %q", logs.String())
+	}
+	if err := c.Call("Test.Echo", "still here", &reply); err != nil || reply != "still here" {
+		t.Errorf("expected the plugin to keep serving, got %q, %v", reply, err)
+	}
+
+	if err := c.Call("Test.Fail", perr.Error(), &reply); err == nil || errors.As(err, &perr) {
+		t.Errorf("expected an error reading like a panic to stay a plain error, got %#v", err)
+	}
+
+	if err := p.Instrument("Missing"); err == nil {
+		t.Error("expected an error instrumenting an unknown service")
+	}
+}
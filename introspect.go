@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+// introspectService is the reserved service through which hosts list the
+// services a running plugin has registered.
+const introspectService = "__Introspect"
+
+// reserved reports whether name is one of the services the package
+// registers for itself, which manifests leave out.
+func reserved(name string) bool {
+	return strings.HasPrefix(name, "__")
+}
+
+// ListMethods returns the services and methods the plugin client talks to
+// has registered now, which may differ from the manifest it sent in its
+// handshake.
+func ListMethods(client *rpc.Client) (*Manifest, error) {
+	m := &Manifest{}
+	err := client.Call(introspectService+".ListMethods", 0, m)
+	return m, err
+}
+
+// ListMethods is the package level ListMethods for the plugin behind c,
+// launching it first if it is not running.
+func (c *Client) ListMethods() (*Manifest, error) {
+	client, _, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(client)
+	m, err := ListMethods(client)
+	return m, classifyError(err)
+}
+
+type introspector struct {
+	d        *dispatcher
+	instance string
+}
+
+func (i introspector) ListMethods(args int, reply *Manifest) error {
+	*reply = *i.d.manifest()
+	return nil
+}
+
+func (i introspector) InstanceID(args int, reply *string) error {
+	*reply = i.instance
+	return nil
+}
+
+// remoteInstanceID asks the plugin client talks to for its instance ID,
+// giving up after timeout.
+func remoteInstanceID(client *rpc.Client, timeout time.Duration) (string, error) {
+	var id string
+	call := client.Go(introspectService+".InstanceID", 0, &id, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return id, call.Error
+	case <-time.After(timeout):
+		return "", HandshakeTimeoutError
+	}
+}
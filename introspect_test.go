@@ -0,0 +1,37 @@
+package plugin
+
+import "testing"
+
+func TestListMethods(t *testing.T) {
+	p, client := pipePlugin(t, newTestAPI())
+	m, err := ListMethods(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Services) != 1 || !m.Has("Test.Echo") || !m.Has("Test.Slow") {
+		t.Fatalf("unexpected methods %+v", m)
+	}
+
+	if err := p.RegisterName("Shout", shoutAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = ListMethods(client); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Services) != 2 || !m.Has("Shout.Echo") {
+		t.Errorf("expected a service registered after the start to be listed, got %+v", m)
+	}
+}
+
+func TestClientListMethods(t *testing.T) {
+	withFakePlugin(t, newTestAPI(), WithPingService())
+	c := StartLazy("test")
+	defer c.Close()
+	m, err := c.ListMethods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Services) != 1 || !m.Has("Test.Echo") || m.Has(PingServiceName+".Ping") {
+		t.Errorf("expected only the plugin's own services, got %+v", m)
+	}
+}
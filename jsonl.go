@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc"
+)
+
+// maxJSONLine is the longest message, in bytes, the JSON-Lines codecs read.
+var maxJSONLine = 16 << 20
+
+var MissingParamsError = Xrror("jsonl: request has no params")
+
+// The JSON-Lines wire format: each request and response is one compact JSON
+// object on a line of its own, such as
+//
+//	{"method":"Test.Echo","params":"hi","id":1}
+//	{"id":1,"result":"hi","error":null}
+//
+// Blank lines between messages are skipped.
+type jsonLinesRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     uint64          `json:"id"`
+}
+
+type jsonLinesResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// NewJSONLinesServerCodec returns a codec serving rpc requests as
+// newline-delimited JSON over rwc, for hosts such as shell scripts that
+// read a line at a time. It can be passed to Plugin.ServeCodec.
+func NewJSONLinesServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	return &jsonLinesServerCodec{rwc: rwc, lines: newLineScanner(rwc), enc: json.NewEncoder(rwc)}
+}
+
+type jsonLinesServerCodec struct {
+	rwc   io.ReadWriteCloser
+	lines *bufio.Scanner
+	enc   *json.Encoder
+	req   jsonLinesRequest
+}
+
+func (c *jsonLinesServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	line, err := readLine(c.lines)
+	if err != nil {
+		return err
+	}
+	c.req = jsonLinesRequest{}
+	if err := json.Unmarshal(line, &c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod, r.Seq = c.req.Method, c.req.ID
+	return nil
+}
+
+func (c *jsonLinesServerCodec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	if c.req.Params == nil {
+		return MissingParamsError
+	}
+	return json.Unmarshal(c.req.Params, body)
+}
+
+func (c *jsonLinesServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	resp := struct {
+		ID     uint64      `json:"id"`
+		Result interface{} `json:"result"`
+		Error  interface{} `json:"error"`
+	}{ID: r.Seq}
+	if r.Error == "" {
+		resp.Result = body
+	} else {
+		resp.Error = r.Error
+	}
+	return c.enc.Encode(resp)
+}
+
+func (c *jsonLinesServerCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// NewJSONLinesClientCodec returns the host's side of
+// NewJSONLinesServerCodec, for use with StartCodec.
+func NewJSONLinesClientCodec(rwc io.ReadWriteCloser) rpc.ClientCodec {
+	return &jsonLinesClientCodec{rwc: rwc, lines: newLineScanner(rwc), enc: json.NewEncoder(rwc)}
+}
+
+type jsonLinesClientCodec struct {
+	rwc   io.ReadWriteCloser
+	lines *bufio.Scanner
+	enc   *json.Encoder
+	resp  jsonLinesResponse
+}
+
+func (c *jsonLinesClientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	return c.enc.Encode(struct {
+		Method string      `json:"method"`
+		Params interface{} `json:"params"`
+		ID     uint64      `json:"id"`
+	}{r.ServiceMethod, param, r.Seq})
+}
+
+func (c *jsonLinesClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	line, err := readLine(c.lines)
+	if err != nil {
+		return err
+	}
+	c.resp = jsonLinesResponse{}
+	if err := json.Unmarshal(line, &c.resp); err != nil {
+		return err
+	}
+	r.Seq = c.resp.ID
+	switch e := c.resp.Error.(type) {
+	case nil:
+	case string:
+		r.Error = e
+		if e == "" {
+			r.Error = "unspecified error"
+		}
+	default:
+		r.Error = fmt.Sprint(e)
+	}
+	return nil
+}
+
+func (c *jsonLinesClientCodec) ReadResponseBody(body interface{}) error {
+	if body == nil || c.resp.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(c.resp.Result, body)
+}
+
+func (c *jsonLinesClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxJSONLine)
+	return s
+}
+
+// readLine returns the next non-blank line, or io.EOF at the end of input.
+func readLine(s *bufio.Scanner) ([]byte, error) {
+	for s.Scan() {
+		if line := s.Bytes(); len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+// teeConn records what is read from its Conn.
+type teeConn struct {
+	net.Conn
+	read bytes.Buffer
+}
+
+func (c *teeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Write(b[:n])
+	return n, err
+}
+
+func TestJSONLinesCodec(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	served := make(chan error, 1)
+	go func() { served <- p.ServeCodec(NewJSONLinesServerCodec) }()
+	tee := &teeConn{Conn: host}
+	client := rpc.NewClientWithCodec(NewJSONLinesClientCodec(tee))
+
+	var reply string
+	if err := client.Call("Test.Echo", "two\nlines", &reply); err != nil || reply != "two\nlines" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if err := client.Call("Test.Missing", "", &reply); err == nil {
+		t.Error("expected an error for an unknown method")
+	} else if _, ok := err.(rpc.ServerError); !ok {
+		t.Errorf("expected a ServerError, got %v", err)
+	}
+	read := append([]byte(nil), tee.read.Bytes()...)
+	client.Close()
+	if err := <-served; err != nil {
+		t.Errorf("unexpected serve error: %s", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(read, []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per response, got %q", read)
+	}
+	for _, line := range lines {
+		var resp map[string]interface{}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Errorf("line %q is not a JSON object: %s", line, err)
+		}
+	}
+}
+
+func TestJSONLinesServerRaw(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	go p.ServeCodec(NewJSONLinesServerCodec)
+	defer host.Close()
+	go io.WriteString(host, "\n{\"method\":\"Test.Echo\",\"params\":\"hi\",\"id\":7}\n")
+	line, err := readLine(newLineScanner(host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":7,"result":"hi","error":null}`; string(line) != want {
+		t.Errorf("expected %s, got %s", want, line)
+	}
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"os"
+	"time"
+)
+
+type resource int
+
+const (
+	resourceMemory resource = iota
+	resourceCPUTime
+)
+
+type rlimit struct {
+	resource   resource
+	soft, hard uint64
+}
+
+var (
+	NiceNotSupportedError   = Xrror("process niceness is not supported on this platform")
+	RlimitNotSupportedError = Xrror("process resource limits are not supported on this platform")
+	PipeSizeError           = Xrror("setting plugin stdout pipe size to %d: %s").Out
+)
+
+// WithNice runs the plugin process at niceness n. Negative values usually
+// need elevated privileges.
+func WithNice(n int) Option {
+	return func(c *config) {
+		c.nice = &n
+	}
+}
+
+// WithMaxMemory limits the plugin's address space to bytes. Resource limits
+// are only supported on Linux, where they are applied with prlimit just after
+// the process starts.
+func WithMaxMemory(bytes uint64) Option {
+	return func(c *config) {
+		c.rlimits = append(c.rlimits, rlimit{resourceMemory, bytes, bytes})
+	}
+}
+
+// WithMaxCPUTime limits the CPU time the plugin may consume; on reaching it
+// the process is sent SIGXCPU, and is killed a second later. The limit
+// counts whole seconds, so d is rounded up, to at least one second.
+func WithMaxCPUTime(d time.Duration) Option {
+	secs := uint64(1)
+	if d > time.Second {
+		secs = uint64((d + time.Second - 1) / time.Second)
+	}
+	return func(c *config) {
+		c.rlimits = append(c.rlimits, rlimit{resourceCPUTime, secs, secs + 1})
+	}
+}
+
+// WithCPUAffinity pins the plugin process to the given CPU cores, numbered
+// from 0, just after it starts. Affinity is only supported on Linux; on
+// other platforms the option is ignored with a logged warning, as macOS
+// only offers affinity hints for a process's own threads.
+func WithCPUAffinity(cpus []int) Option {
+	return func(c *config) {
+		c.cpuAffinity = cpus
+	}
+}
+
+// WithStdoutBufferSize sets the kernel buffer of the pipe carrying the
+// plugin's standard output to n bytes, up from the default 64 KiB, so
+// plugins sending bulk data block and switch context less often. Only
+// Linux can resize pipes; elsewhere the option does nothing. Sizes above
+// /proc/sys/fs/pipe-max-size need CAP_SYS_RESOURCE, and failing to set the
+// size fails the launch with PipeSizeError.
+func WithStdoutBufferSize(n int) Option {
+	return func(c *config) {
+		c.stdoutBufferSize = n
+	}
+}
+
+func (c *config) applyLimits(proc *os.Process) error {
+	if c.nice != nil {
+		if err := setNice(proc.Pid, *c.nice); err != nil {
+			return err
+		}
+	}
+	for _, l := range c.rlimits {
+		if err := setRlimit(proc.Pid, l); err != nil {
+			return err
+		}
+	}
+	if c.cpuAffinity != nil {
+		return setAffinity(proc.Pid, c.cpuAffinity)
+	}
+	return nil
+}
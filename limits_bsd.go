@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package plugin
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func setNice(pid, n int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, n)
+}
+
+func setRlimit(int, rlimit) error {
+	return RlimitNotSupportedError
+}
+
+func setAffinity(int, []int) error {
+	log.Printf("plugin: CPU affinity is not supported on %s, ignoring WithCPUAffinity", runtime.GOOS)
+	return nil
+}
+
+func setPipeSize(*os.File, int) error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func setNice(pid, n int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, n)
+}
+
+func setRlimit(pid int, l rlimit) error {
+	res := syscall.RLIMIT_AS
+	if l.resource == resourceCPUTime {
+		res = syscall.RLIMIT_CPU
+	}
+	lim := syscall.Rlimit{Cur: l.soft, Max: l.hard}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64,
+		uintptr(pid), uintptr(res), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fSetPipeSize and fGetPipeSize are the fcntl commands resizing a pipe.
+const (
+	fSetPipeSize = 1031
+	fGetPipeSize = 1032
+)
+
+func setPipeSize(f *os.File, n int) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fSetPipeSize, uintptr(n))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cpuMask is a cpu_set_t for up to 1024 CPUs.
+type cpuMask [16]uint64
+
+func setAffinity(pid int, cpus []int) error {
+	var mask cpuMask
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= len(mask)*64 {
+			return syscall.EINVAL
+		}
+		mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+		uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
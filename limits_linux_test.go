@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestCPUAffinity(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...), WithCPUAffinity([]int{0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var mask cpuMask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY,
+		uintptr(c.Pid()), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if mask != (cpuMask{1}) {
+		t.Errorf("expected the plugin pinned to CPU 0, got mask %x", mask)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...), WithNice(5), WithMaxMemory(1<<40), WithMaxCPUTime(1500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The raw getpriority syscall returns 20 - nice.
+	if prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, c.Pid()); err != nil || 20-prio != 5 {
+		t.Errorf("expected niceness 5, got %d, %v", 20-prio, err)
+	}
+	for _, tc := range []struct {
+		res        int
+		soft, hard uint64
+	}{
+		{syscall.RLIMIT_AS, 1 << 40, 1 << 40},
+		{syscall.RLIMIT_CPU, 2, 3},
+	} {
+		var lim syscall.Rlimit
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64,
+			uintptr(c.Pid()), uintptr(tc.res), 0, uintptr(unsafe.Pointer(&lim)), 0, 0)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		if lim.Cur != tc.soft || lim.Max != tc.hard {
+			t.Errorf("resource %d: expected limits %d/%d, got %d/%d", tc.res, tc.soft, tc.hard, lim.Cur, lim.Max)
+		}
+	}
+}
+
+func TestMaxCPUTimeRoundsUp(t *testing.T) {
+	for d, want := range map[time.Duration]uint64{
+		0:                       1,
+		100 * time.Millisecond:  1,
+		time.Second:             1,
+		1001 * time.Millisecond: 2,
+	} {
+		c := newConfig([]Option{WithMaxCPUTime(d)})
+		if l := c.rlimits[0]; l.soft != want || l.hard != want+1 {
+			t.Errorf("%s: expected %d seconds, got %+v", d, want, l)
+		}
+	}
+}
+
+func pipeSize(t testing.TB, c *Client) int {
+	f := c.pipe.ReadCloser.(*os.File)
+	raw, err := f.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n uintptr
+	var errno syscall.Errno
+	raw.Control(func(fd uintptr) {
+		n, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fGetPipeSize, 0)
+	})
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	return int(n)
+}
+
+func TestStdoutBufferSize(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...), WithStdoutBufferSize(1<<20))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+	if n := pipeSize(t, c); n != 1<<20 {
+		t.Errorf("expected a 1 MiB pipe buffer, got %d", n)
+	}
+}
+
+func BenchmarkStdoutBufferSize(b *testing.B) {
+	payload := strings.Repeat("x", 4<<20)
+	for _, size := range []int{0, 1 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.Setenv(helperEnv, "1")
+			c, err := NewClient(os.Args[0], WithArgs("-test.run=^TestHelperProcess$", "--", "echo"), WithStdoutBufferSize(size))
+			if err != nil {
+				b.Skip(err)
+			}
+			defer c.Close()
+			b.SetBytes(int64(len(payload)))
+			var reply string
+			for i := 0; i < b.N; i++ {
+				if err := c.Call("Test.Echo", payload, &reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package plugin
+
+import (
+	"log"
+	"os"
+	"runtime"
+)
+
+func setNice(int, int) error {
+	return NiceNotSupportedError
+}
+
+func setRlimit(int, rlimit) error {
+	return RlimitNotSupportedError
+}
+
+func setAffinity(int, []int) error {
+	log.Printf("plugin: CPU affinity is not supported on %s, ignoring WithCPUAffinity", runtime.GOOS)
+	return nil
+}
+
+func setPipeSize(*os.File, int) error {
+	return nil
+}
@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PluginManifest describes how to launch a plugin, for configuring plugins
+// from files rather than code. Name identifies the plugin in launch errors,
+// the manifest's path standing in if it is empty. KillTimeout is in
+// time.ParseDuration form and Codec is "gob", the default, or "json".
+type PluginManifest struct {
+	Name        string
+	Path        string
+	Args        []string
+	Env         map[string]string
+	WorkDir     string
+	KillTimeout string
+	Codec       string
+	InstanceID  string
+	// MaxMessageSize limits the replies the host reads, as
+	// WithMaxMessageSize: 0 for the default, negative for no limit.
+	MaxMessageSize int
+}
+
+var (
+	UnknownCodecError   = Xrror("plugin manifest %s: unknown codec %q").Out
+	ManifestSyntaxError = Xrror("plugin manifest %s:%d: cannot parse %q").Out
+	ManifestFormatError = Xrror("plugin manifest %s: expected a .json or .toml file").Out
+	PluginLoadError     = Xrror("plugin %s: %s").Out
+	manifestExtensions  = map[string]bool{".json": true, ".toml": true}
+)
+
+// LoadPlugin reads the JSON or TOML manifest at manifestPath, chosen by its
+// extension, and launches the plugin it describes. Only the flat subset of
+// TOML the manifest needs is understood: string, integer and string array
+// values, and an [Env] table.
+func LoadPlugin(manifestPath string) (*Client, error) {
+	m, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := m.options(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(m.Path, opts...)
+	if err != nil {
+		return nil, PluginLoadError(m.label(manifestPath), err)
+	}
+	return c, nil
+}
+
+// LoadAllPlugins loads every .json and .toml manifest in dir, in name order.
+// If any fails to load, the plugins already launched are closed.
+func LoadAllPlugins(dir string) ([]*Client, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && manifestExtensions[filepath.Ext(e.Name())] {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	var clients []*Client
+	for _, name := range names {
+		c, err := LoadPlugin(filepath.Join(dir, name))
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func readManifest(path string) (*PluginManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".json":
+	case ".toml":
+		if data, err = tomlToJSON(path, data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ManifestFormatError(path)
+	}
+	m := &PluginManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *PluginManifest) label(path string) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return path
+}
+
+func (m *PluginManifest) options(path string) ([]Option, error) {
+	opts := []Option{WithArgs(m.Args...), WithDir(m.WorkDir), WithInstanceID(m.InstanceID)}
+	for k, v := range m.Env {
+		opts = append(opts, WithEnv(k+"="+v))
+	}
+	if m.KillTimeout != "" {
+		d, err := time.ParseDuration(m.KillTimeout)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKillTimeout(d))
+	}
+	switch m.Codec {
+	case "", "gob":
+	case "json":
+		opts = append(opts, WithJSONCodec())
+	default:
+		return nil, UnknownCodecError(path, m.Codec)
+	}
+	if m.MaxMessageSize != 0 {
+		opts = append(opts, WithMaxMessageSize(m.MaxMessageSize))
+	}
+	return opts, nil
+}
+
+// tomlToJSON converts the manifest subset of TOML to JSON.
+func tomlToJSON(path string, data []byte) ([]byte, error) {
+	doc := map[string]interface{}{}
+	table := doc
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			table = map[string]interface{}{}
+			doc[strings.TrimSpace(line[1:len(line)-1])] = table
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, ManifestSyntaxError(path, n, line)
+		}
+		v, ok := tomlValue(strings.TrimSpace(line[eq+1:]))
+		if !ok {
+			return nil, ManifestSyntaxError(path, n, line)
+		}
+		table[strings.Trim(strings.TrimSpace(line[:eq]), `"`)] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func tomlValue(s string) (interface{}, bool) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		list := []string{}
+		for _, item := range splitTOMLArray(s[1 : len(s)-1]) {
+			v, ok := tomlString(item)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, v)
+		}
+		return list, true
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	return tomlString(s)
+}
+
+func tomlString(s string) (string, bool) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1], true
+	}
+	v, err := strconv.Unquote(s)
+	return v, err == nil && s[0] == '"'
+}
+
+// splitTOMLArray splits the items of a one line array on the commas outside
+// quotes.
+func splitTOMLArray(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
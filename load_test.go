@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoadAllPlugins(t *testing.T) {
+	path, args := helperProcess(t, "json")
+	dir := t.TempDir()
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = strconv.Quote(a)
+	}
+	toml := `# echo plugin over JSON-RPC
+Name = "echo"
+Path = ` + strconv.Quote(path) + `
+Args = [` + strings.Join(quoted, ", ") + `]
+KillTimeout = "2s"
+Codec = 'json'
+
+[Env]
+` + helperEnv + ` = "1"
+`
+	if err := os.WriteFile(filepath.Join(dir, "a.toml"), []byte(toml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := json.Marshal(PluginManifest{
+		Name:  "echo",
+		Path:  path,
+		Args:  args,
+		Env:   map[string]string{helperEnv: "1"},
+		Codec: "json",
+	})
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), manifest, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv(helperEnv)
+
+	clients, err := LoadAllPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("expected 2 plugins, got %d", len(clients))
+	}
+	for _, c := range clients {
+		var reply string
+		if err := c.Call("Test.Echo", "loaded", &reply); err != nil || reply != "loaded" {
+			t.Errorf("unexpected result %q, %v", reply, err)
+		}
+		c.Close()
+	}
+}
+
+func TestLoadPluginNamesFailure(t *testing.T) {
+	dir := t.TempDir()
+	manifest, _ := json.Marshal(PluginManifest{Name: "broken", Path: filepath.Join(dir, "missing")})
+	path := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, manifest, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadPlugin(path)
+	if err == nil || !strings.HasPrefix(err.Error(), "plugin broken: ") || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the launch error under the plugin's name, got %v", err)
+	}
+}
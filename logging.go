@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+type loggingServerCodec struct {
+	rpc.ServerCodec
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	started map[uint64]time.Time
+}
+
+// NewLoggingServerCodec wraps inner to log every request read and response
+// written with its method, sequence number and time spent since the request
+// header was read.
+func NewLoggingServerCodec(inner rpc.ServerCodec, logger *slog.Logger) rpc.ServerCodec {
+	return &loggingServerCodec{ServerCodec: inner, logger: logger, started: make(map[uint64]time.Time)}
+}
+
+func (c *loggingServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		c.logger.Debug("rpc read request header", "error", err)
+		return err
+	}
+	c.mu.Lock()
+	c.started[r.Seq] = time.Now()
+	c.mu.Unlock()
+	c.logger.Info("rpc request", "method", r.ServiceMethod, "seq", r.Seq)
+	return nil
+}
+
+func (c *loggingServerCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if err != nil {
+		c.logger.Warn("rpc read request body", "error", err)
+	}
+	return err
+}
+
+func (c *loggingServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	started, ok := c.started[r.Seq]
+	delete(c.started, r.Seq)
+	c.mu.Unlock()
+	var elapsed time.Duration
+	if ok {
+		elapsed = time.Since(started)
+	}
+	attrs := []interface{}{"method", r.ServiceMethod, "seq", r.Seq, "elapsed", elapsed, "body", logBody(body)}
+	if r.Error != "" {
+		attrs = append(attrs, "error", r.Error)
+	}
+	err := c.ServerCodec.WriteResponse(r, body)
+	if err != nil {
+		attrs = append(attrs, "write_error", err)
+	}
+	c.logger.Info("rpc response", attrs...)
+	return err
+}
+
+func logBody(body interface{}) string {
+	if m, ok := body.(json.Marshaler); ok {
+		if b, err := m.MarshalJSON(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%T", body)
+}
+
+func (c *loggingServerCodec) Flush() error {
+	flush(c.ServerCodec)
+	return nil
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+func TestLoggingServerCodec(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	served := make(chan struct{})
+	go func() {
+		p.ServeCodec(func(rwc io.ReadWriteCloser) rpc.ServerCodec {
+			return NewLoggingServerCodec(newGobServerCodec(rwc), logger)
+		})
+		close(served)
+	}()
+	client := rpc.NewClient(host)
+	var reply string
+	if err := client.Call("Test.Echo", "hi", &reply); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	<-served
+
+	out := logs.String()
+	if !strings.Contains(out, "msg=\"rpc request\" method=Test.Echo") || !strings.Contains(out, "msg=\"rpc response\" method=Test.Echo") {
+		t.Errorf("expected request and response records for Test.Echo, got:\n%s", out)
+	}
+}
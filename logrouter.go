@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRecord is a line a plugin wrote to its standard error, as routed by a
+// LogRouter.
+type LogRecord struct {
+	Time    time.Time  `json:"time"`
+	Plugin  string     `json:"plugin"`
+	Level   slog.Level `json:"level"`
+	Message string     `json:"message"`
+}
+
+// LogRouter merges the standard error of many plugins into one stream of
+// LogRecords, written to its sink as JSON lines in the order the lines
+// arrived, so they interleave with the host's own logs.
+type LogRouter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewLogRouter returns a LogRouter writing records to sink.
+func NewLogRouter(sink io.Writer) *LogRouter {
+	return &LogRouter{enc: json.NewEncoder(sink), now: time.Now}
+}
+
+// Writer returns the writer to pass to WithStderr for the plugin named
+// plugin: each line written to it becomes a LogRecord tagged with plugin
+// and the time the line was complete. A final line without a newline is
+// routed when the plugin exits.
+func (r *LogRouter) Writer(plugin string) io.Writer {
+	return &lineFilter{w: routedLog{r, plugin}, fn: func(string) bool { return true }}
+}
+
+func (r *LogRouter) route(plugin string, line []byte) error {
+	msg := string(bytes.TrimRight(line, "\r\n"))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(LogRecord{Time: r.now(), Plugin: plugin, Level: logLevel(msg), Message: msg})
+}
+
+// routedLog is a LogRouter's writer for one plugin, which is written one
+// line at a time.
+type routedLog struct {
+	r      *LogRouter
+	plugin string
+}
+
+func (l routedLog) Write(line []byte) (int, error) {
+	return len(line), l.r.route(l.plugin, line)
+}
+
+// levelNames maps the level names plugins commonly tag their lines with
+// to slog levels.
+var levelNames = map[string]slog.Level{
+	"TRACE": slog.LevelDebug, "DEBUG": slog.LevelDebug, "DBG": slog.LevelDebug,
+	"INFO": slog.LevelInfo, "INF": slog.LevelInfo, "NOTICE": slog.LevelInfo,
+	"WARN": slog.LevelWarn, "WARNING": slog.LevelWarn, "WRN": slog.LevelWarn,
+	"ERROR": slog.LevelError, "ERR": slog.LevelError, "CRIT": slog.LevelError,
+	"CRITICAL": slog.LevelError, "FATAL": slog.LevelError, "PANIC": slog.LevelError,
+}
+
+// logLevel guesses the level of msg from a level name among its first few
+// words, as in "ERROR: ...", "[warn] ...", "2024/01/02 15:04:05 INFO ..."
+// or slog's "time=... level=WARN ...". Lines without one are at info
+// level, except Go's panic and fatal error reports, which are errors.
+func logLevel(msg string) slog.Level {
+	if strings.HasPrefix(msg, "panic: ") || strings.HasPrefix(msg, "fatal error: ") {
+		return slog.LevelError
+	}
+	words := strings.Fields(msg)
+	if len(words) > 4 {
+		words = words[:4]
+	}
+	for _, w := range words {
+		name := strings.Trim(w, "[]():")
+		// A lower case level name must be marked as one, so that prose
+		// mentioning an error is not taken for one.
+		tagged := name != w || strings.HasPrefix(name, "level=") || name == strings.ToUpper(name)
+		if level, ok := levelNames[strings.ToUpper(strings.TrimPrefix(name, "level="))]; ok && tagged {
+			return level
+		}
+	}
+	return slog.LevelInfo
+}
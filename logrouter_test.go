@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestLogRouter(t *testing.T) {
+	var out bytes.Buffer
+	r := NewLogRouter(&out)
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	a, b := r.Writer("alpha"), r.Writer("beta")
+	a.Write([]byte("INFO starting\nWARN: disk "))
+	b.Write([]byte("[error] connection refused\r\n"))
+	a.Write([]byte("nearly full\n2024/01/02 15:04:05 retrying"))
+	flush(a)
+
+	want := []LogRecord{
+		{now, "alpha", slog.LevelInfo, "INFO starting"},
+		{now, "beta", slog.LevelError, "[error] connection refused"},
+		{now, "alpha", slog.LevelWarn, "WARN: disk nearly full"},
+		{now, "alpha", slog.LevelInfo, "2024/01/02 15:04:05 retrying"},
+	}
+	dec := json.NewDecoder(&out)
+	for i, w := range want {
+		var got LogRecord
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+		if !got.Time.Equal(w.Time) || got.Plugin != w.Plugin || got.Level != w.Level || got.Message != w.Message {
+			t.Errorf("record %d: got %+v, want %+v", i, got, w)
+		}
+	}
+	if dec.More() {
+		t.Error("expected no more records")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for msg, want := range map[string]slog.Level{
+		"DEBUG cache miss":                           slog.LevelDebug,
+		"time=2024-01-02T15:04:05Z level=WARN msg=x": slog.LevelWarn,
+		"2024/01/02 15:04:05 [ERROR] boom":           slog.LevelError,
+		"panic: runtime error: index out of range":   slog.LevelError,
+		"fatal: could not read config":               slog.LevelError,
+		"listening on :8080":                         slog.LevelInfo,
+		"the error budget is fine":                   slog.LevelInfo,
+	} {
+		if got := logLevel(msg); got != want {
+			t.Errorf("logLevel(%q) = %s, want %s", msg, got, want)
+		}
+	}
+}
+
+func TestLogRouterStderr(t *testing.T) {
+	var out bytes.Buffer
+	r := NewLogRouter(&out)
+	path, args := helperProcess(t, "stderr")
+	c, err := NewClient(path, WithArgs(args...), WithStderr(r.Writer("helper")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	var got LogRecord
+	if err := json.NewDecoder(&out).Decode(&got); err != nil || got.Plugin != "helper" || got.Message != "hello stderr" {
+		t.Errorf("expected the plugin's stderr to be routed, got %+v, %v", got, err)
+	}
+}
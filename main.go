@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// PanicExitCode is the exit code of a plugin that panicked under Main or
+// Go, which hosts see as an AbnormalExitError whose Panicked reports true.
+const PanicExitCode = 86
+
+// crashMarker starts the crash record Main writes to standard error.
+const crashMarker = "PLUGIN-CRASH "
+
+// CrashRecord is the record of a panic that Main writes to standard error,
+// as a single line of JSON after "PLUGIN-CRASH ".
+type CrashRecord struct {
+	Time  time.Time `json:"time"`
+	Panic string    `json:"panic"`
+	Stack string    `json:"stack"`
+}
+
+// Main runs a plugin's main function and exits: with 0 if it returns nil,
+// 1 if it returns an error and PanicExitCode if it panics, after logging the
+// panic with the standard logger and writing a CrashRecord to standard
+// error. Panics in other goroutines are only caught if they were started
+// with Go.
+//
+// Main also ignores SIGPIPE, which would otherwise kill a plugin writing to
+// its stdout or stderr after the host has gone, without running deferred
+// cleanup. Such writes fail with EPIPE instead; Serve drops replies it can
+// no longer send and returns once it reads the host's EOF, so run returns
+// and its deferred calls run as on any clean shutdown.
+func Main(run func() error) {
+	ignoreBrokenPipe()
+	defer exitOnPanic()
+	if err := run(); err != nil {
+		log.Printf("plugin failed: %s", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Go runs fn in a new goroutine, exiting as Main does if it panics.
+func Go(fn func()) {
+	go func() {
+		defer exitOnPanic()
+		fn()
+	}()
+}
+
+func exitOnPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	record := CrashRecord{Time: time.Now(), Panic: fmt.Sprint(r), Stack: string(debug.Stack())}
+	log.Printf("plugin panicked: %s", record.Panic)
+	if b, err := json.Marshal(record); err == nil {
+		fmt.Fprintf(os.Stderr, "%s%s\n", crashMarker, b)
+	}
+	os.Exit(PanicExitCode)
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	DuplicatePluginError = Xrror("plugin %s is already managed").Out
+	DependencyCycleError = Xrror("plugin dependency cycle: %s").Out
+)
+
+// Manager owns a set of named plugin clients and shuts them down in
+// dependency order: a plugin is always stopped before the plugins it
+// depends on.
+type Manager struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	deps    map[string][]string
+	names   []string
+	events  chan Event
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		clients: make(map[string]*Client),
+		deps:    make(map[string][]string),
+		events:  make(chan Event, eventBuffer),
+	}
+}
+
+func (m *Manager) Add(name string, c *Client) error {
+	return m.AddWithDeps(name, c, nil)
+}
+
+// AddWithDeps adds c under name, depending on the plugins named in deps.
+// Dependencies may be added later, but one that would close a cycle is
+// rejected with DependencyCycleError and c is not added.
+func (m *Manager) AddWithDeps(name string, c *Client, deps []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[name]; ok {
+		return DuplicatePluginError(name)
+	}
+	m.deps[name] = deps
+	if cycle := m.findCycle(name); cycle != nil {
+		delete(m.deps, name)
+		return DependencyCycleError(strings.Join(cycle, " -> "))
+	}
+	m.clients[name] = c
+	m.names = append(m.names, name)
+	instance := c.InstanceID()
+	c.setNotify(func(typ EventType, err error) { m.emit(name, instance, typ, err) })
+	return nil
+}
+
+func (m *Manager) Get(name string) (*Client, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[name]
+	return c, ok
+}
+
+func (m *Manager) findCycle(from string) []string {
+	var path []string
+	onPath := make(map[string]bool)
+	var visit func(string) bool
+	visit = func(name string) bool {
+		path = append(path, name)
+		if onPath[name] {
+			return true
+		}
+		onPath[name] = true
+		for _, dep := range m.deps[name] {
+			if visit(dep) {
+				return true
+			}
+		}
+		onPath[name] = false
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(from) {
+		return path
+	}
+	return nil
+}
+
+// shutdownOrder lists managed plugins with every plugin ahead of its
+// dependencies, keeping insertion order otherwise.
+func (m *Manager) shutdownOrder() []string {
+	var order []string
+	done := make(map[string]bool)
+	var visit func(string)
+	visit = func(name string) {
+		if done[name] {
+			return
+		}
+		done[name] = true
+		for _, dep := range m.deps[name] {
+			visit(dep)
+		}
+		if _, ok := m.clients[name]; ok {
+			order = append(order, name)
+		}
+	}
+	for _, name := range m.names {
+		visit(name)
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Shutdown closes every managed plugin, dependents before dependencies,
+// and returns the last error encountered.
+func (m *Manager) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for _, name := range m.shutdownOrder() {
+		if closeErr := m.clients[name].Close(); closeErr != nil {
+			err = closeErr
+		}
+		delete(m.clients, name)
+	}
+	m.names = nil
+	return err
+}
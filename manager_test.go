@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManagerShutdownOrder(t *testing.T) {
+	m := NewManager()
+	m.AddWithDeps("api", StartLazy("api"), []string{"db", "cache"})
+	m.AddWithDeps("cache", StartLazy("cache"), []string{"db"})
+	m.Add("db", StartLazy("db"))
+	m.Add("metrics", StartLazy("metrics"))
+
+	want := []string{"metrics", "api", "cache", "db"}
+	if got := m.shutdownOrder(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected shutdown order %v, got %v", want, got)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Errorf("unexpected shutdown error: %s", err)
+	}
+	if _, ok := m.Get("db"); ok {
+		t.Error("expected plugins removed after shutdown")
+	}
+}
+
+func TestManagerRejectsCycle(t *testing.T) {
+	m := NewManager()
+	m.AddWithDeps("a", StartLazy("a"), []string{"b"})
+	m.AddWithDeps("b", StartLazy("b"), []string{"c"})
+	if err := m.AddWithDeps("c", StartLazy("c"), []string{"a"}); err == nil {
+		t.Fatal("expected dependency cycle error")
+	}
+	if _, ok := m.Get("c"); ok {
+		t.Error("expected plugin closing a cycle not to be added")
+	}
+}
+
+func TestManagerEvents(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	m := NewManager()
+	m.Add("echo", StartLazy("echo", IdleTimeout(10*time.Millisecond)))
+	c, _ := m.Get("echo")
+
+	var reply string
+	c.Call("Test.Echo", "hi", &reply)
+	time.Sleep(30 * time.Millisecond)
+	c.Call("Test.Echo", "hi", &reply)
+	m.Shutdown()
+
+	want := []EventType{EventStarted, EventHandshake, EventStopped, EventRestarted, EventHandshake, EventStopped}
+	for _, typ := range want {
+		select {
+		case ev := <-m.Events():
+			if ev.Type != typ || ev.Plugin != "echo" || ev.Err != nil {
+				t.Fatalf("expected %s event for echo, got %+v", typ, ev)
+			}
+		default:
+			t.Fatalf("missing %s event", typ)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"net/rpc"
+	"sort"
+	"strings"
+)
+
+// Manifest describes the services a plugin had registered when it began
+// serving, as announced to the host during the handshake.
+type Manifest struct {
+	Services []ServiceInfo `json:"services"`
+}
+
+type ServiceInfo struct {
+	Name    string       `json:"name"`
+	Methods []MethodInfo `json:"methods"`
+}
+
+// MethodInfo gives a method's argument and reply types as Go type names.
+type MethodInfo struct {
+	Name  string `json:"name"`
+	Args  string `json:"args"`
+	Reply string `json:"reply"`
+}
+
+var UnsupportedMethodError = Xrror("plugin does not provide %s").Out
+
+// Has reports whether serviceMethod, given as "Service.Method", is listed.
+func (m *Manifest) Has(serviceMethod string) bool {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return false
+	}
+	for _, s := range m.Services {
+		if s.Name != serviceMethod[:dot] {
+			continue
+		}
+		for _, mt := range s.Methods {
+			if mt.Name == serviceMethod[dot+1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Manifest returns the manifest the plugin sent in its handshake, or nil if
+// it has not been launched yet or did not handshake. Plugins may register
+// and unregister services after the handshake, so the manifest only gates
+// the error reported: calls the plugin cannot find that the manifest does
+// not list either fail with UnsupportedMethodError.
+func (c *Client) Manifest() *Manifest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.manifest
+}
+
+func (d *dispatcher) manifest() *Manifest {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	m := &Manifest{}
+	for _, s := range d.services {
+		if reserved(s.name) {
+			continue
+		}
+		info := ServiceInfo{Name: s.name}
+		for name, mt := range s.methods {
+			info.Methods = append(info.Methods, MethodInfo{name, mt.argType.String(), mt.replyType.String()})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+		m.Services = append(m.Services, info)
+	}
+	sort.Slice(m.Services, func(i, j int) bool { return m.Services[i].Name < m.Services[j].Name })
+	return m
+}
+
+func unsupported(m *Manifest, serviceMethod string, err error) error {
+	se, ok := err.(rpc.ServerError)
+	if !ok || m == nil || m.Has(serviceMethod) || !strings.HasPrefix(string(se), "rpc: can't find ") {
+		return err
+	}
+	return &ProtocolError{UnsupportedMethodError(serviceMethod)}
+}
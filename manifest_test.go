@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	m := c.Manifest()
+	if m == nil || len(m.Services) != 1 || m.Services[0].Name != "Test" {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	want := MethodInfo{"Echo", "string", "*string"}
+	if got := m.Services[0].Methods[0]; got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if !m.Has("Test.Slow") || m.Has("Test.Missing") {
+		t.Errorf("unexpected Has results for %+v", m)
+	}
+
+	var reply string
+	if err := c.Call("Test.Missing", "", &reply); !errors.As(err, new(*ProtocolError)) {
+		t.Errorf("expected a ProtocolError for an unlisted method, got %v", err)
+	}
+}
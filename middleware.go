@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Middleware wraps the handler for method, given as "Service.Method", with
+// logic that runs around every call to it. args and reply are the values
+// the method is called with.
+type Middleware func(method string, handler func(args, reply interface{}) error) func(args, reply interface{}) error
+
+// Use wraps every method registered on the plugin with mw. Middleware added
+// first runs outermost. Use must be called before Serve.
+func (p *Plugin) Use(mw Middleware) {
+	p.dispatch.mu.Lock()
+	defer p.dispatch.mu.Unlock()
+	p.dispatch.middleware = append(p.dispatch.middleware, mw)
+}
+
+// served reports whether a call to a service other than the reserved ones
+// has been made.
+func (d *dispatcher) served() bool {
+	return atomic.LoadInt32(&d.userCalls) != 0
+}
+
+func (d *dispatcher) handler(s *service, mt *methodType) func(args, reply interface{}) error {
+	h := func(args, reply interface{}) error {
+		if !reserved(s.name) {
+			atomic.StoreInt32(&d.userCalls, 1)
+		}
+		return s.call(mt, reflect.ValueOf(args), reflect.ValueOf(reply))
+	}
+	if s.name == contextService {
+		// The call it unwraps goes through the middleware itself.
+		return h
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	method := s.name + "." + mt.method.Name
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		h = d.middleware[i](method, h)
+	}
+	return h
+}
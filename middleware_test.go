@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+type CallInfo struct {
+	Method string
+}
+
+type infoAPI struct{}
+
+func (infoAPI) Method(args string, reply *CallInfo) error {
+	return nil
+}
+
+func (infoAPI) Other(args string, reply *CallInfo) error {
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Info", conn, infoAPI{})
+	var calls []string
+	p.Use(func(method string, next func(args, reply interface{}) error) func(args, reply interface{}) error {
+		return func(args, reply interface{}) error {
+			calls = append(calls, method)
+			return next(args, reply)
+		}
+	})
+	p.Use(func(method string, next func(args, reply interface{}) error) func(args, reply interface{}) error {
+		return func(args, reply interface{}) error {
+			err := next(args, reply)
+			reply.(*CallInfo).Method = method
+			return err
+		}
+	})
+	go p.Serve()
+	client := rpc.NewClient(host)
+	defer client.Close()
+
+	for _, method := range []string{"Info.Method", "Info.Other"} {
+		var reply CallInfo
+		if err := client.Call(method, "", &reply); err != nil || reply.Method != method {
+			t.Errorf("expected middleware to record %s, got %+v, %v", method, reply, err)
+		}
+	}
+	if len(calls) != 2 || calls[0] != "Info.Method" || calls[1] != "Info.Other" {
+		t.Errorf("expected middleware to run for every method, got %v", calls)
+	}
+}
+
+func TestMiddlewareWithContext(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Info", conn, infoAPI{})
+	var calls []string
+	p.Use(func(method string, next func(args, reply interface{}) error) func(args, reply interface{}) error {
+		return func(args, reply interface{}) error {
+			calls = append(calls, method)
+			err := next(args, reply)
+			reply.(*CallInfo).Method = method
+			return err
+		}
+	})
+	go p.Serve()
+	c := NewClientFromConn(host)
+	defer c.Close()
+
+	var reply CallInfo
+	if err := c.SendContext(CallContext{}, "Info.Method", "", &reply); err != nil || reply.Method != "Info.Method" {
+		t.Errorf("expected middleware to see the unwrapped call, got %+v, %v", reply, err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("expected the middleware to run once, got %v", calls)
+	}
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"bufio"
+	"io"
+	"math"
+)
+
+const (
+	// defaultMaxMessageSize is the largest message, in bytes, either end
+	// reads unless WithMaxMessageSize says otherwise.
+	defaultMaxMessageSize = 64 << 20
+	// messageLimitUnlimited is how config records WithMaxMessageSize(0),
+	// its zero value standing for the default.
+	messageLimitUnlimited = -1
+)
+
+var (
+	MessageTooLargeError = Xrror("plugin message exceeds the maximum message size")
+	MessageLengthError   = Xrror("gob: malformed message length")
+)
+
+// WithMaxMessageSize limits the messages the end it is given to reads to n
+// bytes, 64MiB by default, so a buggy or malicious peer cannot exhaust its
+// memory; n of 0 lifts the limit. With the gob codec an oversize message is
+// skipped without being buffered and only its call fails, with a
+// ProtocolError wrapping MessageTooLargeError: on the host when a reply is
+// too large, and in the plugin, which answers with the error, when a
+// request is. An oversize header, a reply carrying the first value of its
+// type the plugin sends, which comes after the type's definition, or any
+// oversize message with the JSON codec ends the connection instead.
+//
+// Host functions that take no options, Start and its kin, read replies
+// with the default limit; DockerOptions, PluginConfig and PluginManifest
+// have a MaxMessageSize field of their own.
+func WithMaxMessageSize(n int) Option {
+	return func(c *config) {
+		c.maxMessageSize = n
+		if n <= 0 {
+			c.maxMessageSize = messageLimitUnlimited
+		}
+	}
+}
+
+// messageLimit returns the limit WithMaxMessageSize set, or 0 for none.
+func (c *config) messageLimit() int {
+	switch {
+	case c.maxMessageSize == 0:
+		return defaultMaxMessageSize
+	case c.maxMessageSize < 0:
+		return 0
+	}
+	return c.maxMessageSize
+}
+
+// gobLimiter sits between a gob decoder and its buffered connection and
+// follows the length prefix of each gob message. It hands the decoder one
+// message at a time, and skips a message longer than max, failing the
+// read that would have started it with MessageTooLargeError. As the
+// decoder clears its error on each Decode, the next one carries on with
+// the following message.
+type gobLimiter struct {
+	r      *bufio.Reader
+	max    int
+	left   int
+	prefix []byte
+	buf    [9]byte
+}
+
+// limitGob returns r for a gob decoder, limited to messages of max bytes
+// unless max is 0.
+func limitGob(r *bufio.Reader, max int) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &gobLimiter{r: r, max: max}
+}
+
+func (l *gobLimiter) Read(p []byte) (int, error) {
+	if err := l.begin(); err != nil {
+		return 0, err
+	}
+	if len(l.prefix) > 0 {
+		n := copy(p, l.prefix)
+		l.prefix = l.prefix[n:]
+		return n, nil
+	}
+	if len(p) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= n
+	return n, err
+}
+
+// ReadByte stops the decoder wrapping the limiter in a buffer of its own,
+// which would read ahead into the next message.
+func (l *gobLimiter) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(l, b[:])
+	return b[0], err
+}
+
+// begin reads the length prefix of the next message if the last one has
+// been read in full.
+func (l *gobLimiter) begin() error {
+	if len(l.prefix) > 0 || l.left > 0 {
+		return nil
+	}
+	b, err := l.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	l.buf[0] = b
+	prefix, n := l.buf[:1], uint64(b)
+	if b >= 0x80 {
+		width := -int(int8(b))
+		if width > 8 {
+			return MessageLengthError
+		}
+		if _, err := io.ReadFull(l.r, l.buf[1:1+width]); err != nil {
+			return err
+		}
+		prefix, n = l.buf[:1+width], 0
+		for _, c := range prefix[1:] {
+			n = n<<8 | uint64(c)
+		}
+	}
+	if n > uint64(l.max) {
+		if n > math.MaxInt64 {
+			return MessageLengthError
+		}
+		if _, err := io.CopyN(io.Discard, l.r, int64(n)); err != nil {
+			return err
+		}
+		return MessageTooLargeError
+	}
+	l.prefix, l.left = prefix, int(n)
+	return nil
+}
+
+// jsonLimiter fails reading a JSON value, an RPC message of the JSON codec,
+// once it runs past max bytes. It tracks where each top-level value ends by
+// following brackets and quotes; bare numbers and literals end at
+// whitespace or the next value.
+type jsonLimiter struct {
+	io.ReadWriteCloser
+	max      int
+	size     int
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+// limitJSON returns rwc for a JSON codec, limited to messages of max bytes
+// unless max is 0.
+func limitJSON(rwc io.ReadWriteCloser, max int) io.ReadWriteCloser {
+	if max <= 0 {
+		return rwc
+	}
+	return &jsonLimiter{ReadWriteCloser: rwc, max: max}
+}
+
+func (l *jsonLimiter) Read(p []byte) (int, error) {
+	n, err := l.ReadWriteCloser.Read(p)
+	for _, c := range p[:n] {
+		l.size++
+		top := l.depth == 0
+		switch {
+		case l.inString:
+			switch {
+			case l.escaped:
+				l.escaped = false
+			case c == '\\':
+				l.escaped = true
+			case c == '"':
+				l.inString = false
+			}
+		case c == '"':
+			if top {
+				l.size = 1
+			}
+			l.inString = true
+		case c == '{' || c == '[':
+			if top {
+				l.size = 1
+			}
+			l.depth++
+		case c == '}' || c == ']':
+			if l.depth > 0 {
+				l.depth--
+			}
+		}
+		if l.size > l.max {
+			return 0, MessageTooLargeError
+		}
+		if l.depth == 0 && !l.inString && (!top || c == '"' || c == ' ' || c == '\t' || c == '\r' || c == '\n') {
+			l.size = 0
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type bulkAPI struct{}
+
+func (bulkAPI) Make(n int, reply *string) error {
+	*reply = strings.Repeat("x", n)
+	return nil
+}
+
+func (bulkAPI) Len(args string, reply *int) error {
+	*reply = len(args)
+	return nil
+}
+
+func TestMaxMessageSizeReply(t *testing.T) {
+	withFakePlugin(t, bulkAPI{})
+	c, err := NewClient("test", WithMaxMessageSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply string
+	if err := c.Call("Test.Make", 4096, &reply); !errors.Is(err, MessageTooLargeError) {
+		t.Fatalf("expected MessageTooLargeError for an oversize reply, got %v", err)
+	}
+	if err := c.Call("Test.Make", 10, &reply); err != nil || len(reply) != 10 {
+		t.Errorf("expected only the oversize call to fail, got %d bytes, %v", len(reply), err)
+	}
+}
+
+func TestMaxMessageSizeRequest(t *testing.T) {
+	withFakePlugin(t, bulkAPI{}, WithMaxMessageSize(1024))
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var n int
+	err = c.Call("Test.Len", strings.Repeat("x", 4096), &n)
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || !errors.Is(err, MessageTooLargeError) {
+		t.Fatalf("expected a ProtocolError for an oversize request, got %#v", err)
+	}
+	if err := c.Call("Test.Len", "small", &n); err != nil || n != 5 {
+		t.Errorf("expected the plugin to keep serving, got %d, %v", n, err)
+	}
+}
+
+func TestMaxMessageSizeUnlimited(t *testing.T) {
+	withFakePlugin(t, bulkAPI{}, WithMaxMessageSize(0))
+	c, err := NewClient("test", WithMaxMessageSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var n int
+	if err := c.Call("Test.Len", strings.Repeat("x", defaultMaxMessageSize+1), &n); err != nil || n != defaultMaxMessageSize+1 {
+		t.Errorf("expected no limit, got %d, %v", n, err)
+	}
+}
+
+func TestGobLimiterOversizeFrame(t *testing.T) {
+	var stream bytes.Buffer
+	enc := gob.NewEncoder(&stream)
+	enc.Encode("first")
+	// A frame announcing far more data than follows, as a hostile peer
+	// might send, must be rejected without allocating for it.
+	stream.Write([]byte{0xfb, 0x01, 0x00, 0x00, 0x00, 0x00})
+	dec := gob.NewDecoder(limitGob(bufio.NewReader(&stream), 64))
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "first" {
+		t.Fatalf("unexpected first message %q, %v", s, err)
+	}
+	if err := dec.Decode(&s); err != io.EOF {
+		t.Errorf("expected the truncated oversize frame to end the stream, got %v", err)
+	}
+
+	stream.Reset()
+	enc = gob.NewEncoder(&stream)
+	enc.Encode("small")
+	enc.Encode(strings.Repeat("x", 100))
+	enc.Encode("after")
+	dec = gob.NewDecoder(limitGob(bufio.NewReader(&stream), 64))
+	for _, want := range []string{"small", "", "after"} {
+		var got string
+		err := dec.Decode(&got)
+		if want == "" && err != MessageTooLargeError || want != "" && (err != nil || got != want) {
+			t.Errorf("expected %q, got %q, %v", want, got, err)
+		}
+	}
+}
+
+func TestMaxMessageSizeJSON(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, bulkAPI{}, WithMaxMessageSize(1024))
+	served := make(chan error, 1)
+	go func() { served <- p.ServeJSON() }()
+	c := NewClientFromConn(host, WithJSONCodec())
+	defer c.Close()
+	var n int
+	if err := c.Call("Test.Len", `quoted "}" `+strings.Repeat("x", 900), &n); err != nil {
+		t.Fatalf("expected a message under the limit to pass, got %v", err)
+	}
+	c.Call("Test.Len", strings.Repeat("x", 4096), &n)
+	if err := <-served; err != MessageTooLargeError {
+		t.Errorf("expected an oversize JSON message to end serving, got %v", err)
+	}
+}
+
+func TestJSONLimiterTopLevel(t *testing.T) {
+	for _, stream := range []string{
+		`"` + strings.Repeat("x", 100) + `"`,
+		strings.Repeat("1", 100),
+		`{} "a" ` + strings.Repeat("1", 100),
+	} {
+		r := strings.NewReader(stream)
+		_, err := io.ReadAll(limitJSON(readWriteCloser{r, io.Discard, io.NopCloser(r)}, 64))
+		if err != MessageTooLargeError {
+			t.Errorf("expected %.20q... to be too large, got %v", stream, err)
+		}
+	}
+	small := `{"a": "}"} "` + strings.Repeat("x", 50) + `" 12345 [1, 2]` + "\n" + strings.Repeat("1", 60)
+	r := strings.NewReader(small)
+	if _, err := io.ReadAll(limitJSON(readWriteCloser{r, io.Discard, io.NopCloser(r)}, 64)); err != nil {
+		t.Errorf("expected values under the limit to pass, got %v", err)
+	}
+}
+
+func TestMaxMessageSizeHost(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	clients, err := StartGroup([]PluginConfig{{Path: path, Args: args, MaxMessageSize: 1024}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+	var reply string
+	if err := clients[0].Call("Test.Echo", strings.Repeat("x", 4096), &reply); err == nil || err.Error() != MessageTooLargeError.Error() {
+		t.Errorf("expected the group's limit to apply, got %v", err)
+	}
+
+	path, args = helperProcess(t, "json")
+	m := &PluginManifest{Path: path, Args: args, Codec: "json", MaxMessageSize: 1024}
+	client, _, err := m.launch("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("Test.Echo", strings.Repeat("x", 4096), &reply); err == nil {
+		t.Error("expected the manifest's limit to apply to a JSON client")
+	}
+}
@@ -0,0 +1,153 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+const (
+	// mtlsAccepted is written by the plugin once the host's certificate
+	// checks out; see awaitAccepted.
+	mtlsAccepted     byte = 1
+	mtlsDialInterval      = 10 * time.Millisecond
+)
+
+var NoCACertificatesError = Xrror("no CA certificates found in PEM data")
+
+// mtlsConfig returns the TLS configuration both ends of a mutual TLS
+// connection use: each presents cert and requires the other to present a
+// certificate signed by ca.
+func mtlsConfig(ca, cert, key []byte) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, NoCACertificatesError
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// StartMTLS starts the plugin at path, which must listen on address with
+// NewMTLS, and connects to it over mutual TLS: ca, cert and key are PEM
+// encoded, the plugin's certificate must be signed by ca and valid for the
+// host in address, and the plugin requires cert to be signed by its CA in
+// turn. The plugin's stdin still tells it the host has gone. StartMTLS
+// gives up if the handshake has not completed within 5 seconds.
+func StartMTLS(ca, cert, key []byte, address string, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	conf, err := mtlsConfig(ca, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	if conf.ServerName, _, err = net.SplitHostPort(address); err != nil {
+		return nil, err
+	}
+	pipe, err := start(makeCommand(output, path, args))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialMTLS(address, conf, time.Now().Add(handshakeTimeout))
+	if err == nil {
+		if err = awaitAccepted(conn); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	return newClient(mtlsConn{conn, pipe}, defaultMaxMessageSize), nil
+}
+
+// dialMTLS dials address until the plugin is listening, or deadline
+// passes, and completes the handshake.
+func dialMTLS(address string, conf *tls.Config, deadline time.Time) (*tls.Conn, error) {
+	for {
+		raw, err := net.DialTimeout("tcp", address, time.Until(deadline))
+		if err != nil {
+			if time.Now().Add(mtlsDialInterval).After(deadline) {
+				return nil, err
+			}
+			time.Sleep(mtlsDialInterval)
+			continue
+		}
+		conn := tls.Client(raw, conf)
+		conn.SetDeadline(deadline)
+		if err := conn.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// awaitAccepted reads the byte the plugin writes once it has verified the
+// host's certificate. With TLS 1.3 the client's handshake completes before
+// the server has checked its certificate, so a rejection would otherwise
+// only surface as the first call failing.
+func awaitAccepted(conn *tls.Conn) error {
+	defer conn.SetDeadline(time.Time{})
+	_, err := io.ReadFull(conn, make([]byte, 1))
+	return err
+}
+
+// mtlsConn is the host's transport over a TLS connection, which closes the
+// pipe to the process on Close.
+type mtlsConn struct {
+	*tls.Conn
+	pipe *ioPipe
+}
+
+func (c mtlsConn) Close() error {
+	c.Conn.Close()
+	return c.pipe.Close()
+}
+
+// NewMTLS is New for a plugin started with StartMTLS: it listens on
+// listenAddr for the host to connect over mutual TLS and serves api on the
+// first connection that presents a certificate signed by ca, closing the
+// listener. Connections that fail the handshake, or stall it past
+// handshakeTimeout, are dropped. The plugin
+// presents cert and key, PEM encoded, and stops serving when its stdin is
+// closed.
+func NewMTLS(ca, cert, key []byte, listenAddr, name string, api interface{}, opts ...Option) (*Plugin, error) {
+	conf, err := mtlsConfig(ca, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := tls.Listen("tcp", listenAddr, conf)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := conn.(*tls.Conn).Handshake(); err != nil {
+			conn.Close()
+			continue
+		}
+		if _, err := conn.Write([]byte{mtlsAccepted}); err != nil {
+			conn.Close()
+			continue
+		}
+		conn.SetDeadline(time.Time{})
+		go closeOnEOF(os.Stdin, conn)
+		return newPlugin(name, "", conn, api, opts), nil
+	}
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "plugin test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and key for 127.0.0.1, usable by
+// either end of a connection.
+func (ca *testCA) issue(t *testing.T, name string) (cert, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &priv.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// mtlsPlugin writes the plugin's CA, certificate and key where the "mtls"
+// helper reads them and returns its command line and a free address.
+func mtlsPlugin(t *testing.T, ca *testCA) (path string, args []string, address string) {
+	t.Helper()
+	dir := t.TempDir()
+	cert, key := ca.issue(t, "plugin")
+	for name, data := range map[string][]byte{"ca.pem": ca.pem, "cert.pem": cert, "key.pem": key} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address = l.Addr().String()
+	l.Close()
+	path, args = helperProcess(t, "mtls")
+	return path, append(args, dir, address), address
+}
+
+func TestStartMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	path, args, address := mtlsPlugin(t, ca)
+	cert, key := ca.issue(t, "host")
+	client, err := StartMTLS(ca.pem, cert, key, address, os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "mutual", &reply); err != nil || reply != "mutual" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestStartMTLSUntrusted(t *testing.T) {
+	ca := newTestCA(t)
+	path, args, address := mtlsPlugin(t, ca)
+	cert, key := newTestCA(t).issue(t, "impostor")
+	if client, err := StartMTLS(ca.pem, cert, key, address, os.Stderr, path, args...); err == nil {
+		client.Close()
+		t.Fatal("expected a host certificate from another CA to be rejected")
+	}
+	if _, err := StartMTLS([]byte("not a CA"), cert, key, address, os.Stderr, path, args...); err != NoCACertificatesError {
+		t.Errorf("expected NoCACertificatesError, got %v", err)
+	}
+}
+
+func TestNewMTLSStalledHandshake(t *testing.T) {
+	defer func(timeout time.Duration) { handshakeTimeout = timeout }(handshakeTimeout)
+	handshakeTimeout = 100 * time.Millisecond
+
+	ca := newTestCA(t)
+	_, _, address := mtlsPlugin(t, ca)
+	cert, key := ca.issue(t, "plugin")
+	served := make(chan error, 1)
+	go func() {
+		p, err := NewMTLS(ca.pem, cert, key, address, "Test", newTestAPI())
+		if err == nil {
+			p.Close()
+		}
+		served <- err
+	}()
+
+	// A connection that never starts its handshake must not hold up the
+	// host connecting after it.
+	var stalled net.Conn
+	for deadline := time.Now().Add(5 * time.Second); stalled == nil; {
+		conn, err := net.Dial("tcp", address)
+		if err != nil && time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		stalled = conn
+		time.Sleep(mtlsDialInterval)
+	}
+	defer stalled.Close()
+
+	hostCert, hostKey := ca.issue(t, "host")
+	conf, err := mtlsConfig(ca.pem, hostCert, hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ServerName = "127.0.0.1"
+	conn, err := dialMTLS(address, conf, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := awaitAccepted(conn); err != nil {
+		t.Fatalf("expected the host to be accepted, got %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error from NewMTLS: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// namedPipeEnv passes the plugin the name of the pipe to connect to.
+const namedPipeEnv = "PLUGIN_PIPE"
+
+var NamedPipeNotSupportedError = Xrror("named pipe transport is only supported on Windows")
+
+var namedPipes uint64
+
+// WithNamedPipe carries RPC over a Windows named pipe rather than the
+// plugin's stdio. For each launch the host creates a pipe named
+// \\.\pipe\plugin-<host pid>-<n>, local clients only, and passes the name
+// to the plugin in PLUGIN_PIPE, where New connects to it. Unlike the
+// console pipes it supports deadlines. The plugin's stdin is still used to
+// tell it the host has gone. Elsewhere launching fails with
+// NamedPipeNotSupportedError. Both host and plugin must be built with Go
+// 1.25 or later, the first whose os.NewFile puts a handle opened for
+// overlapped I/O on the runtime poller; older releases fail the pipe's
+// first read or write.
+func WithNamedPipe() Option {
+	return func(c *config) {
+		c.namedPipe = true
+	}
+}
+
+func namedPipeName() string {
+	n := atomic.AddUint64(&namedPipes, 1)
+	return `\\.\pipe\plugin-` + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(n, 10)
+}
+
+// listenNamedPipe creates a pipe for the plugin cmd will start and adds
+// its name to cmd's environment.
+func listenNamedPipe(cmd commander) (*namedPipe, error) {
+	e, ok := cmd.(execCmd)
+	if !ok {
+		return nil, NamedPipeNotSupportedError
+	}
+	name := namedPipeName()
+	np, err := createNamedPipe(name)
+	if err != nil {
+		return nil, err
+	}
+	e.Env = append(e.Env, namedPipeEnv+"="+name)
+	return np, nil
+}
+
+// namedPipeConn is the host's transport over a connected pipe, which
+// closes the pipe to the process on Close.
+type namedPipeConn struct {
+	*os.File
+	pipe *ioPipe
+}
+
+func (c namedPipeConn) Close() error {
+	c.File.Close()
+	return c.pipe.Close()
+}
+
+// attach waits for the plugin behind pipe to connect, closing both if it
+// does not within handshakeTimeout.
+func (np *namedPipe) attach(pipe *ioPipe) (namedPipeConn, error) {
+	f, err := np.accept(handshakeTimeout)
+	if err != nil {
+		pipe.Close()
+		return namedPipeConn{}, err
+	}
+	return namedPipeConn{f, pipe}, nil
+}
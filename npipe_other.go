@@ -0,0 +1,26 @@
+//go:build !windows
+
+package plugin
+
+import (
+	"os"
+	"time"
+)
+
+type namedPipe struct{}
+
+func createNamedPipe(string) (*namedPipe, error) {
+	return nil, NamedPipeNotSupportedError
+}
+
+func (*namedPipe) accept(time.Duration) (*os.File, error) {
+	return nil, NamedPipeNotSupportedError
+}
+
+func (*namedPipe) Close() error {
+	return nil
+}
+
+func dialNamedPipe(string) (*os.File, error) {
+	return nil, NamedPipeNotSupportedError
+}
@@ -0,0 +1,29 @@
+package plugin
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNamedPipe(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...), WithNamedPipe())
+	if runtime.GOOS != "windows" {
+		if err != NamedPipeNotSupportedError {
+			t.Errorf("expected NamedPipeNotSupportedError, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply string
+	if err := c.Call("Test.Echo", "over a named pipe", &reply); err != nil || reply != "over a named pipe" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if err := c.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Errorf("expected named pipes to support deadlines, got %v", err)
+	}
+}
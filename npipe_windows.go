@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW     = kernel32.NewProc("CreateEventW")
+	procGetOverlapped    = kernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeBufferSize            = 64 << 10
+
+	errorPipeConnected syscall.Errno = 535
+)
+
+type namedPipe struct {
+	name   string
+	handle syscall.Handle
+}
+
+// createNamedPipe creates the server end of a single instance pipe opened
+// for overlapped I/O, which os.NewFile hands to the runtime poller so it
+// supports deadlines. os.NewFile only does so from Go 1.25, see
+// WithNamedPipe.
+func createNamedPipe(name string) (*namedPipe, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, _, errno := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(p)),
+		pipeAccessDuplex|syscall.FILE_FLAG_OVERLAPPED|fileFlagFirstPipeInstance,
+		pipeRejectRemoteClients, 1, pipeBufferSize, pipeBufferSize, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, os.NewSyscallError("CreateNamedPipe", errno)
+	}
+	return &namedPipe{name, syscall.Handle(h)}, nil
+}
+
+// accept waits up to timeout for the plugin to connect.
+func (np *namedPipe) accept(timeout time.Duration) (*os.File, error) {
+	if err := np.connect(timeout); err != nil {
+		np.Close()
+		return nil, err
+	}
+	return os.NewFile(uintptr(np.handle), np.name), nil
+}
+
+func (np *namedPipe) connect(timeout time.Duration) error {
+	h, _, errno := procCreateEventW.Call(0, 1, 0, 0)
+	if h == 0 {
+		return os.NewSyscallError("CreateEvent", errno)
+	}
+	ev := syscall.Handle(h)
+	defer syscall.CloseHandle(ev)
+	ov := syscall.Overlapped{HEvent: ev}
+	ok, _, errno := procConnectNamedPipe.Call(uintptr(np.handle), uintptr(unsafe.Pointer(&ov)))
+	switch {
+	case ok != 0, errno == errorPipeConnected:
+		return nil
+	case errno != syscall.ERROR_IO_PENDING:
+		return os.NewSyscallError("ConnectNamedPipe", errno)
+	}
+	if s, _ := syscall.WaitForSingleObject(ev, uint32(timeout/time.Millisecond)); s == syscall.WAIT_TIMEOUT {
+		syscall.CancelIoEx(np.handle, &ov)
+		overlappedResult(np.handle, &ov, true)
+		return HandshakeTimeoutError
+	}
+	return overlappedResult(np.handle, &ov, false)
+}
+
+func overlappedResult(h syscall.Handle, ov *syscall.Overlapped, wait bool) error {
+	var n uint32
+	var w uintptr
+	if wait {
+		w = 1
+	}
+	ok, _, errno := procGetOverlapped.Call(uintptr(h), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), w)
+	if ok == 0 {
+		return os.NewSyscallError("GetOverlappedResult", errno)
+	}
+	return nil
+}
+
+func (np *namedPipe) Close() error {
+	return syscall.CloseHandle(np.handle)
+}
+
+// dialNamedPipe connects the plugin to the host's pipe.
+func dialNamedPipe(name string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateFile", err)
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
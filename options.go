@@ -0,0 +1,310 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+type Option func(*config)
+
+type config struct {
+	args              []string
+	argsErr           error
+	stderr            io.Writer
+	idleTimeout       time.Duration
+	compress          bool
+	compressLevel     int
+	methodTimeouts    map[string]time.Duration
+	nice              *int
+	rlimits           []rlimit
+	cpuAffinity       []int
+	uid, gid          *uint32
+	captureStderr     bool
+	startAttempts     int
+	startBackoff      time.Duration
+	shm               *sharedMemory
+	namedPipe         bool
+	pingService       bool
+	bufferSize        int
+	rateLimit         float64
+	rateBurst         int
+	rateWait          time.Duration
+	callbackRateLimit float64
+	callbackRateBurst int
+	launcher          string
+	launcherArgs      []string
+	batchConcurrency  int
+	env               []string
+	dir               string
+	killTimeout       time.Duration
+	jsonCodec         bool
+	pollInterval      time.Duration
+	stderrFilter      func(string) bool
+	commandFunc       func(path string, args []string) *exec.Cmd
+	commandWrapper    func(path string, args []string) (string, []string)
+	maxMessageSize    int
+	secrets           map[string]string
+	stdoutBufferSize  int
+	instanceID        string
+	preKill           func(osProcess)
+	serviceName       string
+	traceInject       func(context.Context) string
+	startSpan         func(traceparent, serviceMethod string) func(error)
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *config) command(path string) (commander, error) {
+	if c.argsErr != nil {
+		return nil, c.argsErr
+	}
+	args := c.args
+	if c.launcher != "" {
+		args = append(append(append([]string(nil), c.launcherArgs...), path), c.args...)
+		path = c.launcher
+	}
+	if c.commandWrapper != nil {
+		path, args = c.commandWrapper(path, append([]string(nil), args...))
+	}
+	stderr := c.stderr
+	if stderr != nil && c.stderrFilter != nil {
+		stderr = &lineFilter{w: stderr, fn: c.stderrFilter}
+	}
+	var cmd commander
+	if c.commandFunc != nil {
+		cmd = c.customCommand(stderr, path, args)
+	} else {
+		cmd = makeCommand(stderr, path, args)
+	}
+	if e, ok := cmd.(execCmd); ok {
+		env := e.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		e.Env = append(append(env, handshakeEnvVar()), c.env...)
+		if c.instanceID != "" {
+			e.Env = append(e.Env, instanceIDEnv+"="+c.instanceID)
+		}
+		if c.dir != "" {
+			e.Dir = c.dir
+		}
+		e.started = c.applyLimits
+		e.pipeSize = c.stdoutBufferSize
+		if c.captureStderr {
+			e.Stderr = nil
+			e.captureStderr = true
+		}
+		if err := c.setCredentials(e.Cmd); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return cmd, nil
+}
+
+// WithCommandFunc builds the plugin's command with fn in place of
+// exec.Command, for settings no option covers, such as Cancel, WaitDelay or
+// a SysProcAttr of one's own. fn gets the path and arguments the other
+// options settled on. Stdin and Stdout are always replaced by the pipes the
+// RPC connection runs over, and Stderr is set as usual when fn leaves it
+// nil. Env, when fn sets it, replaces the inherited environment the
+// handshake and WithEnv variables are added to.
+func WithCommandFunc(fn func(path string, args []string) *exec.Cmd) Option {
+	return func(c *config) {
+		c.commandFunc = fn
+	}
+}
+
+func (c *config) customCommand(stderr io.Writer, path string, args []string) commander {
+	cmd := c.commandFunc(path, args)
+	cmd.Stdin, cmd.Stdout = nil, nil
+	if cmd.Stderr == nil {
+		cmd.Stderr = stderr
+	} else if c.stderrFilter != nil {
+		cmd.Stderr = &lineFilter{w: cmd.Stderr, fn: c.stderrFilter}
+	}
+	setProcessGroup(cmd)
+	return execCmd{Cmd: cmd}
+}
+
+// WithArgs starts the plugin with args. An argument containing a null
+// byte, which the operating system cannot pass, fails the launch with
+// InvalidArgError; see ArgsBuilder for building arguments to check.
+func WithArgs(args ...string) Option {
+	return func(c *config) {
+		c.args, c.argsErr = args, nil
+		for _, arg := range args {
+			if err := checkArg(arg, false); err != nil {
+				c.argsErr = err
+				break
+			}
+		}
+	}
+}
+
+// WithEnv adds env, as "KEY=value" strings, to the environment the plugin
+// inherits from the host.
+func WithEnv(env ...string) Option {
+	return func(c *config) {
+		c.env = append(c.env, env...)
+	}
+}
+
+// WithDir runs the plugin in dir rather than the host's working directory.
+func WithDir(dir string) Option {
+	return func(c *config) {
+		c.dir = dir
+	}
+}
+
+// WithServiceName registers the plugin's API under name rather than under
+// the plugin's own name, which then only identifies it in logs. Plugin
+// binaries implementing the same service contract can so share one service
+// name whatever they are called.
+func WithServiceName(name string) Option {
+	return func(c *config) {
+		c.serviceName = name
+	}
+}
+
+// WithKillTimeout sets how long Close waits for the plugin to exit after
+// interrupting it before killing it.
+func WithKillTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.killTimeout = d
+	}
+}
+
+// WithJSONCodec talks to the plugin with JSON-RPC rather than gob, for
+// plugins served with ServeJSON.
+func WithJSONCodec() Option {
+	return func(c *config) {
+		c.jsonCodec = true
+	}
+}
+
+// WithStderr copies the plugin's standard error to w. Without it, or any of
+// the other stderr options, the plugin's standard error is discarded.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) {
+		c.stderr = w
+	}
+}
+
+// WithStderrDiscard connects the plugin's standard error to the null
+// device, which is the default.
+func WithStderrDiscard() Option {
+	return func(c *config) {
+		c.stderr = nil
+		c.captureStderr = false
+	}
+}
+
+// WithStderrInherit shares the host's standard error with the plugin.
+func WithStderrInherit() Option {
+	return func(c *config) {
+		c.stderr = os.Stderr
+		c.captureStderr = false
+	}
+}
+
+// CaptureStderrPipe connects the plugin's standard error to a pipe read
+// through Client.Stderr, in place of any WithStderr writer.
+func CaptureStderrPipe() Option {
+	return func(c *config) {
+		c.captureStderr = true
+	}
+}
+
+// IdleTimeout shuts the plugin process down once d has passed without a
+// call, and relaunches it transparently on the next call. The saving in
+// memory and process slots is paid for with the full launch latency on the
+// first call after every idle period, so it suits rarely used plugins rather
+// than ones called in steady bursts.
+func IdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithCompression compresses the RPC stream with flate at level, which is
+// one of the compress/flate levels. Compression is only used when both the
+// host and the plugin ask for it during the handshake.
+func WithCompression(level int) Option {
+	return func(c *config) {
+		c.compress = true
+		c.compressLevel = level
+	}
+}
+
+// MethodTimeout bounds calls to method, given as "Service.Method", to d. A
+// call that overruns closes the connection to the plugin, failing any other
+// calls in flight; the plugin is relaunched on the next call.
+func MethodTimeout(method string, d time.Duration) Option {
+	return func(c *config) {
+		if c.methodTimeouts == nil {
+			c.methodTimeouts = make(map[string]time.Duration)
+		}
+		c.methodTimeouts[method] = d
+	}
+}
+
+// WithStartRetry makes up to attempts tries at launching the plugin, waiting
+// backoff after the first failure and doubling the wait after each one, to
+// ride out the plugin binary being briefly missing while it is replaced.
+func WithStartRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.startAttempts = attempts
+		c.startBackoff = backoff
+	}
+}
+
+// WithLauncher runs the plugin through a wrapper command such as sudo,
+// firejail, nsenter or docker run, executing launcher with prefixArgs
+// followed by the plugin path and its arguments. The launcher must hand its
+// stdin and stdout to the plugin unbuffered, as exec-style wrappers do;
+// ones that detach or buffer them, such as docker run without -i, ssh with
+// a pseudo-terminal or sudo requiring a password on the terminal, break the
+// RPC stream. Signals on Close reach the launcher, which must forward them
+// or exit along with the plugin.
+func WithLauncher(launcher string, prefixArgs []string) Option {
+	return func(c *config) {
+		c.launcher = launcher
+		c.launcherArgs = prefixArgs
+	}
+}
+
+// WithCommandWrapper runs the plugin through a wrapper command computed by
+// wrap, which gets the path and arguments the plugin would be started with
+// and returns those to start instead; for sudo, for example:
+//
+//	func(path string, args []string) (string, []string) {
+//		return "sudo", append([]string{"-n", path}, args...)
+//	}
+//
+// It is WithLauncher for wrappers whose arguments depend on the plugin, and
+// is applied after it. The same caveats about stdio and signals apply.
+func WithCommandWrapper(wrap func(path string, args []string) (string, []string)) Option {
+	return func(c *config) {
+		c.commandWrapper = wrap
+	}
+}
+
+const defaultPollInterval = 50 * time.Millisecond
+
+// ServePollInterval sets how often ServeUntil checks its condition, by
+// default every 50ms.
+func ServePollInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = d
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestStderrOptions(t *testing.T) {
+	for _, tc := range []struct {
+		opts []Option
+		want io.Writer
+	}{
+		{nil, nil},
+		{[]Option{WithStderrInherit()}, os.Stderr},
+		{[]Option{CaptureStderrPipe(), WithStderrDiscard()}, nil},
+	} {
+		cmd, err := newConfig(tc.opts).command("plugin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := cmd.(execCmd)
+		if e.Stderr != tc.want || e.captureStderr {
+			t.Errorf("expected stderr %v without capture, got %v, capture %t", tc.want, e.Stderr, e.captureStderr)
+		}
+	}
+}
+
+func TestWithLauncher(t *testing.T) {
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip(err)
+	}
+	path, args := helperProcess(t, "echo")
+	c, err := NewClient(path, WithArgs(args...), WithLauncher(env, []string{"PLUGIN_LAUNCHED=1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply string
+	if err := c.Call("Test.Echo", "launched", &reply); err != nil || reply != "launched" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestWithCommandWrapper(t *testing.T) {
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip(err)
+	}
+	path, args := helperProcess(t, "echo")
+	wrap := WithCommandWrapper(func(path string, args []string) (string, []string) {
+		return env, append([]string{"PLUGIN_WRAPPED=1", path}, args...)
+	})
+	cmd, err := newConfig([]Option{WithArgs(args...), wrap}).command(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := cmd.(execCmd); e.Path != env || len(e.Args) < 3 || e.Args[2] != path {
+		t.Errorf("expected %s to run the plugin, got %q", env, e.Args)
+	}
+
+	c, err := NewClient(path, WithArgs(args...), wrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply string
+	if err := c.Call("Test.Echo", "wrapped", &reply); err != nil || reply != "wrapped" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestWithCommandFunc(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	var built *exec.Cmd
+	c, err := NewClient(path, WithArgs(args...), WithCommandFunc(func(path string, args []string) *exec.Cmd {
+		built = exec.Command(path, args...)
+		built.Stdout = os.Stdout
+		built.Env = append(os.Environ(), "PLUGIN_CUSTOM=1")
+		built.WaitDelay = time.Second
+		return built
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var reply string
+	if err := c.Call("Test.Echo", "custom", &reply); err != nil || reply != "custom" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if built == nil || built.WaitDelay != time.Second || built.Env[len(built.Env)-1] != handshakeEnvVar() {
+		t.Errorf("expected the custom command to be used with the handshake added to its environment")
+	}
+}
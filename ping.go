@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"net/rpc"
+	"sync/atomic"
+	"time"
+)
+
+// PingServiceName is the reserved service name WithPingService registers
+// PingService under. Plugin APIs must not use it, nor any other name
+// starting with "__".
+const PingServiceName = "__Ping"
+
+var PingTimeoutError = Xrror("plugin did not answer ping in time")
+
+// WithPingService has the plugin serve PingService, the standard liveness
+// check that hosts call with Client.Ping or Ping.
+func WithPingService() Option {
+	return func(c *config) {
+		c.pingService = true
+	}
+}
+
+// PingService answers pings. Ping echoes its argument, so a host can tell
+// its own ping's answer from a stale one.
+type PingService struct{}
+
+func (PingService) Ping(req uint64, resp *uint64) error {
+	*resp = req
+	return nil
+}
+
+var pingSeq uint64
+
+// Ping checks that the plugin behind client is serving, failing with
+// PingTimeoutError if it has not answered within timeout. The plugin must
+// have been created WithPingService.
+func Ping(client *rpc.Client, timeout time.Duration) error {
+	req := atomic.AddUint64(&pingSeq, 1)
+	var resp uint64
+	call := client.Go(PingServiceName+".Ping", req, &resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return PingTimeoutError
+	}
+}
+
+// Ping checks the plugin is serving, as the package level Ping does,
+// launching it first if it is not running.
+func (c *Client) Ping(timeout time.Duration) error {
+	client, _, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release(client)
+	return classifyError(Ping(client, timeout))
+}
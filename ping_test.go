@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	withFakePlugin(t, newTestAPI(), WithPingService())
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Ping(time.Second); err != nil {
+		t.Errorf("unexpected ping error: %s", err)
+	}
+
+	_, client := pipePlugin(t, newTestAPI())
+	if err := Ping(client, time.Second); err == nil {
+		t.Error("expected ping to fail without the ping service")
+	}
+
+	// A plugin that never answers stands in for a hung one.
+	host, conn := net.Pipe()
+	go io.Copy(io.Discard, conn)
+	if err := Ping(rpc.NewClient(host), 20*time.Millisecond); err != PingTimeoutError {
+		t.Errorf("expected PingTimeoutError, got %v", err)
+	}
+}
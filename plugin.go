@@ -1,42 +1,279 @@
 package plugin
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"net/rpc"
+	"net/rpc/jsonrpc"
 	"os"
 	"os/exec"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Plugin struct {
 	name, path string
-	*rpc.Server
+	service    string
 	io.ReadWriteCloser
+	dispatch  *dispatcher
+	conf      *config
+	handshake bool
+	ready     bool
+	debug     *http.Server
+	stdout    io.Writer
+	instance  string
+	bus       *subscriptions
 }
 
 func (p *Plugin) Close() error {
+	if p.debug != nil {
+		p.debug.Close()
+	}
 	return p.ReadWriteCloser.Close()
 }
 
-func (p *Plugin) Serve() {
-	p.Server.ServeConn(p)
+// Serve answers calls until the host closes the connection. It returns only
+// once the calls in progress have returned, their replies have been written
+// and flushed through the codec and any compression, the connection has
+// been closed and standard error synced, so a plugin may exit as soon as
+// Serve returns without losing its last reply or log lines.
+//
+// The error returned says why serving stopped: nil when the host hung up
+// cleanly, otherwise the handshake or codec error that ended it. Plugins
+// run by Main can return it from their run function to have it logged;
+// simple plugins may ignore it.
+func (p *Plugin) Serve() error {
+	return p.ServeCodec(func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		return newGobServerCodecSize(conn, p.conf.bufferSize, p.conf.messageLimit())
+	})
+}
+
+func (p *Plugin) ServeCodec(fn func(io.ReadWriteCloser) rpc.ServerCodec) error {
+	conn, err := p.transport()
+	if err != nil {
+		log.Printf("plugin %s handshake failed: %s", p.label(), err)
+		p.Close()
+		return err
+	}
+	err = p.dispatch.serveCodec(fn(conn))
+	flush(p.stdout)
+	os.Stderr.Sync()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// ServeUntil serves like Serve until cond reports true, checking it every
+// ServePollInterval, then closes the plugin to stop serving. It returns nil
+// if it stopped serving because cond held.
+func (p *Plugin) ServeUntil(cond func() bool) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Serve()
+	}()
+	interval := p.conf.pollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-tick.C:
+			if cond() {
+				p.Close()
+				<-done
+				return nil
+			}
+		}
+	}
+}
+
+// ServeN serves like Serve but reads only n requests, then closes the
+// connection once their replies have been written. Calls beyond the n-th
+// fail on the host as the connection going away.
+func (p *Plugin) ServeN(n int) error {
+	return p.ServeCodec(func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		return &limitCodec{ServerCodec: newGobServerCodecSize(conn, p.conf.bufferSize, p.conf.messageLimit()), left: int64(n)}
+	})
+}
+
+// limitCodec ends a connection after a fixed number of requests by
+// reporting EOF in place of the next request header.
+type limitCodec struct {
+	rpc.ServerCodec
+	left int64
+}
+
+func (c *limitCodec) ReadRequestHeader(r *rpc.Request) error {
+	if atomic.AddInt64(&c.left, -1) < 0 {
+		return io.EOF
+	}
+	return c.ServerCodec.ReadRequestHeader(r)
+}
+
+func (c *limitCodec) Flush() error {
+	flush(c.ServerCodec)
+	return nil
+}
+
+func (p *Plugin) ServeJSON() error {
+	return p.ServeCodec(func(conn io.ReadWriteCloser) rpc.ServerCodec {
+		return jsonrpc.NewServerCodec(limitJSON(conn, p.conf.messageLimit()))
+	})
+}
+
+func (p *Plugin) transport() (io.ReadWriteCloser, error) {
+	if !p.handshake {
+		return p.ReadWriteCloser, nil
+	}
+	conn, _, err := handshake(p.ReadWriteCloser, p.conf, p.dispatch.manifest())
+	return conn, err
+}
+
+func (p *Plugin) Register(rcvr interface{}) error {
+	return p.RegisterName(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name(), rcvr)
+}
+
+// RegisterName registers rcvr as the service name. The receiver is checked
+// as net/rpc would check it, but served by the plugin's own dispatcher, which
+// unlike rpc.Server allows services to be unregistered and registered again.
+func (p *Plugin) RegisterName(name string, rcvr interface{}) error {
+	if err := rpc.NewServer().RegisterName(name, rcvr); err != nil {
+		return err
+	}
+	return p.dispatch.register(name, rcvr)
+}
+
+// HotReload replaces the implementation of the plugin's own service, the
+// one New registered under the plugin's service name, with newAPI. Calls
+// already in progress finish on the old implementation; calls read after
+// HotReload returns use newAPI.
+func (p *Plugin) HotReload(newAPI interface{}) error {
+	if err := rpc.NewServer().RegisterName(p.service, newAPI); err != nil {
+		return err
+	}
+	return p.dispatch.replace(p.service, newAPI)
+}
+
+// Unregister removes the service name, so calls to it fail as calls to an
+// unknown service do. Calls to it already in progress run to completion.
+func (p *Plugin) Unregister(name string) error {
+	return p.dispatch.unregister(name)
+}
+
+// DecodeStdin decodes a JSON value written ahead of the RPC stream by
+// StartWithStdin. Anything the decoder buffered past the value is handed back
+// to the plugin's reader, so it must be called before Serve.
+func (p *Plugin) DecodeStdin(v interface{}) error {
+	dec := json.NewDecoder(p.ReadWriteCloser)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	rw := p.ReadWriteCloser
+	p.ReadWriteCloser = readWriteCloser{io.MultiReader(dec.Buffered(), rw), rw, rw}
+	return nil
+}
+
+// Drain stops the plugin accepting new calls, which are answered with
+// ShuttingDownError, and blocks until the calls already in progress have
+// returned or timeout has passed.
+func (p *Plugin) Drain(timeout time.Duration) error {
+	return p.dispatch.drain(timeout)
 }
 
-func (p *Plugin) ServeCodec(fn func(io.ReadWriteCloser) rpc.ServerCodec) {
-	p.Server.ServeCodec(fn(p))
+// BeginDrain is Drain without the wait: new calls are answered with
+// ShuttingDownError from now on, while calls in progress carry on.
+func (p *Plugin) BeginDrain() {
+	p.dispatch.beginDrain()
 }
 
-func New(name, path string, api interface{}) *Plugin {
+func New(name, path string, api interface{}, opts ...Option) *Plugin {
+	p := newPlugin(name, path, rwc(os.Stdin, os.Stdout), api, opts)
+	p.handshake = os.Getenv(handshakeEnv) != ""
+	p.ready = os.Getenv(readyEnv) != ""
+	p.RegisterName(initService, &initializer{d: p.dispatch})
+	if p.conf.shm != nil {
+		conn, err := p.conf.shm.open(false)
+		if err != nil {
+			log.Fatalf("failed to attach Plugin %s to shared memory: %s", name, err)
+		}
+		go closeOnEOF(os.Stdin, conn)
+		p.ReadWriteCloser = conn
+	}
+	if pipe := os.Getenv(namedPipeEnv); pipe != "" {
+		conn, err := dialNamedPipe(pipe)
+		if err != nil {
+			log.Fatalf("failed to connect Plugin %s to named pipe %s: %s", name, pipe, err)
+		}
+		go closeOnEOF(os.Stdin, conn)
+		p.ReadWriteCloser = conn
+	}
+	if err := p.captureStdout(); err != nil {
+		log.Fatalf("failed to capture the standard output of Plugin %s: %s", name, err)
+	}
+	return p
+}
+
+// NewFromConn returns a Plugin that serves api over conn, an already
+// accepted connection, rather than over standard input and output. Closing
+// the plugin closes conn.
+func NewFromConn(name string, conn net.Conn, api interface{}, opts ...Option) *Plugin {
+	return newPlugin(name, "", conn, api, opts)
+}
+
+// NewInProcess serves api as a Plugin named name over one end of a net.Pipe
+// and returns it with a client for the other end. It stands in for a plugin
+// started as a subprocess in tests, with no binary to build; closing the
+// client ends serving.
+func NewInProcess(name string, api interface{}, opts ...Option) (*Plugin, *rpc.Client) {
+	host, conn := net.Pipe()
+	p := NewFromConn(name, conn, api, opts...)
+	go p.Serve()
+	return p, rpc.NewClient(host)
+}
+
+func newPlugin(name, path string, conn io.ReadWriteCloser, api interface{}, opts []Option) *Plugin {
 	p := &Plugin{
 		name:            name,
 		path:            path,
-		Server:          rpc.NewServer(),
-		ReadWriteCloser: rwc(os.Stdin, os.Stdout),
+		ReadWriteCloser: conn,
+		dispatch:        newDispatcher(),
+		conf:            newConfig(opts),
+		stdout:          newLineWriter(os.Stderr),
 	}
-	if err := p.RegisterName(name, api); err != nil {
+	p.service = name
+	if p.conf.serviceName != "" {
+		p.service = p.conf.serviceName
+	}
+	p.dispatch.limiter = p.conf.rateLimiter()
+	p.setInstance()
+	if err := p.RegisterName(p.service, api); err != nil {
 		log.Fatalf("failed to register Plugin %s: %s", name, err)
 	}
+	p.RegisterName(introspectService, introspector{p.dispatch, p.instance})
+	p.RegisterName(contextService, contextUnwrapper{p.dispatch, p.conf.startSpan})
+	p.bus = &subscriptions{}
+	p.RegisterName(busService, p.bus)
+	p.RegisterName(shutdownService, shutdowner{p})
+	if _, ok := api.(Snapshottable); ok {
+		p.RegisterName(snapshotService, snapshotter{p})
+	}
+	if _, ok := api.(ConfigReloader); ok {
+		p.RegisterName(configService, configReloader{p})
+	}
+	if p.conf.pingService {
+		p.RegisterName(PingServiceName, PingService{})
+	}
 	return p
 }
 
@@ -45,7 +282,54 @@ func Start(output io.Writer, path string, args ...string) (*rpc.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return rpc.NewClient(pipe), nil
+	return newClient(pipe, defaultMaxMessageSize), nil
+}
+
+// StartWithStdin writes all of r to the plugin's standard input before any
+// RPC traffic, for plugins that read their configuration from stdin on
+// startup. The plugin must not read past the end of r; see DecodeStdin.
+func StartWithStdin(r io.Reader, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	pipe, err := start(makeCommand(output, path, args))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(pipe.WriteCloser, r); err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	return newClient(pipe, defaultMaxMessageSize), nil
+}
+
+var ErrStartTimeout = Xrror("timed out starting plugin process")
+
+// StartWithDeadline is Start giving up with ErrStartTimeout if the process
+// has not started by deadline, as when the binary is on a hung network
+// mount. A process that starts after the deadline is killed.
+func StartWithDeadline(deadline time.Time, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	type result struct {
+		pipe *ioPipe
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		pipe, err := start(makeCommand(output, path, args))
+		done <- result{pipe, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return newClient(r.pipe, defaultMaxMessageSize), nil
+	case <-time.After(time.Until(deadline)):
+		go func() {
+			if r := <-done; r.err == nil {
+				r.pipe.proc.Kill()
+				r.pipe.Close()
+			}
+		}()
+		return nil, ErrStartTimeout
+	}
 }
 
 func StartCodec(
@@ -57,13 +341,20 @@ func StartCodec(
 	if err != nil {
 		return nil, err
 	}
-	return rpc.NewClientWithCodec(fn(pipe)), nil
+	return newClientWithCodec(fn(pipe)), nil
+}
+
+func StartJSON(output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	return StartCodec(func(conn io.ReadWriteCloser) rpc.ClientCodec {
+		return jsonrpc.NewClientCodec(limitJSON(conn, defaultMaxMessageSize))
+	}, output, path, args...)
 }
 
 var makeCommand = func(w io.Writer, path string, args []string) commander {
 	cmd := exec.Command(path, args...)
 	cmd.Stderr = w
-	return execCmd{cmd}
+	setProcessGroup(cmd)
+	return execCmd{Cmd: cmd}
 }
 
 //func StartConsumer(output io.Writer, path string, args ...string) (Server, error) {
@@ -87,13 +378,39 @@ var makeCommand = func(w io.Writer, path string, args []string) commander {
 
 type execCmd struct {
 	*exec.Cmd
+	started       func(*os.Process) error
+	captureStderr bool
+	pipeSize      int
 }
 
 func (e execCmd) Start() (osProcess, error) {
 	if err := e.Cmd.Start(); err != nil {
 		return nil, err
 	}
-	return e.Cmd.Process, nil
+	if e.started != nil {
+		if err := e.started(e.Cmd.Process); err != nil {
+			e.Cmd.Process.Kill()
+			e.Cmd.Wait()
+			return nil, err
+		}
+	}
+	return newProcessGroup(cmdProcess{e.Cmd.Process, e.Cmd}, e.Cmd.Process.Pid), nil
+}
+
+// cmdProcess waits through exec.Cmd rather than os.Process so the goroutine
+// copying the plugin's stderr to a non-file writer is finished and released.
+type cmdProcess struct {
+	*os.Process
+	cmd *exec.Cmd
+}
+
+func (c cmdProcess) Wait() (*os.ProcessState, error) {
+	err := c.cmd.Wait()
+	flush(c.cmd.Stderr)
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+	return c.cmd.ProcessState, err
 }
 
 type commander interface {
@@ -111,18 +428,90 @@ type osProcess interface {
 type ioPipe struct {
 	io.ReadCloser
 	io.WriteCloser
-	proc osProcess
+	proc        osProcess
+	pid         int
+	exit        *exitStatus
+	stderr      io.ReadCloser
+	stopTimeout time.Duration
+	stopBy      time.Time
+	skipDrain   bool
+	preKill     func(osProcess)
+	closing     int32
+	once        sync.Once
+	closeErr    error
 }
 
-func (iop ioPipe) Close() error {
-	err := iop.ReadCloser.Close()
-	if writeErr := iop.WriteCloser.Close(); writeErr != nil {
-		err = writeErr
+// Close shuts the plugin down in the order RPC needs. It first closes the
+// plugin's standard input, so the plugin reads a clean EOF and can finish
+// writing the replies it has in progress, then drains its standard output
+// until the plugin closes it or the stop timeout passes, and only then
+// closes the read side and reaps the process. Tearing the read side down
+// first, as it used to be, left a plugin still writing its last replies to
+// a closed pipe, which it saw as a broken pipe or connection reset rather
+// than as the host hanging up. The drain and the wait for the process
+// share the one stop timeout, and Read fails from the start, so the RPC
+// client's reader stops rather than competing with the drain. With a stop
+// deadline set, everything up to the reaping of a killed process is done
+// by then: half the time left goes to the drain, a quarter to the wait
+// after the interrupt and a quarter to the wait after the kill.
+func (iop *ioPipe) Close() error {
+	iop.once.Do(func() {
+		atomic.StoreInt32(&iop.closing, 1)
+		deadline := time.Now().Add(iop.timeout())
+		drained, killWait := deadline, killWaitTimeout
+		if !iop.stopBy.IsZero() {
+			left := time.Until(iop.stopBy)
+			drained = time.Now().Add(left / 2)
+			killWait = left / 4
+			deadline = iop.stopBy.Add(-killWait)
+		}
+		err := iop.WriteCloser.Close()
+		if !iop.skipDrain {
+			iop.drain(time.Until(drained))
+		}
+		if readErr := iop.ReadCloser.Close(); readErr != nil {
+			err = readErr
+		}
+		if procErr := stopProc(iop.proc, iop.exit, time.Until(deadline), killWait, iop.preKill); procErr != nil {
+			err = procErr
+		}
+		iop.closeErr = err
+	})
+	return iop.closeErr
+}
+
+func (iop *ioPipe) Read(b []byte) (int, error) {
+	if atomic.LoadInt32(&iop.closing) != 0 {
+		return 0, io.ErrClosedPipe
 	}
-	if procErr := iop.closeProc(); procErr != nil {
-		err = procErr
+	return iop.ReadCloser.Read(b)
+}
+
+// drain discards the plugin's output until EOF or timeout.
+func (iop *ioPipe) drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, iop.ReadCloser)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
 	}
-	return err
+}
+
+// Stderr returns the plugin's standard error when it was started with
+// CaptureStderrPipe, and nil otherwise. The pipe is closed once the process
+// has been waited on by Close, so it should be read before then.
+func (iop *ioPipe) Stderr() io.Reader {
+	if iop.stderr == nil {
+		return nil
+	}
+	return iop.stderr
+}
+
+func (iop *ioPipe) setStopTimeout(d time.Duration) {
+	iop.stopTimeout = d
 }
 
 var (
@@ -131,27 +520,59 @@ var (
 	KillProcessError     = Xrror("error killing process after timeout: %s").Out
 )
 
-func (iop ioPipe) closeProc() error {
+func (iop *ioPipe) timeout() time.Duration {
+	if iop.stopTimeout <= 0 {
+		return procTimeout
+	}
+	return iop.stopTimeout
+}
+
+// killWaitTimeout is how long stopProc usually waits for a killed process
+// to be reaped.
+var killWaitTimeout = 5 * time.Second
+
+// stopProc interrupts proc and waits up to timeout for it to exit,
+// recording its state in exit, before running preKill, if set, and
+// killing it. It then waits up to killWait for the killed process to be
+// reaped; the process is reaped in the background all the same.
+func stopProc(proc osProcess, exit *exitStatus, timeout, killWait time.Duration, preKill func(osProcess)) error {
 	result := make(chan error, 1)
-	go func() { _, err := iop.proc.Wait(); result <- err }()
-	if err := iop.proc.Signal(os.Interrupt); err != nil {
+	go func() {
+		state, err := proc.Wait()
+		exit.set(state)
+		result <- err
+	}()
+	if err := proc.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return err
 	}
 	select {
 	case err := <-result:
-		return err
-	case <-time.After(procTimeout):
-		if err := iop.proc.Kill(); err != nil {
-			return KillProcessError(err.Error())
+		if err != nil {
+			return err
+		}
+		return abnormalExit(exit.get())
+	case <-time.After(timeout):
+		if preKill != nil {
+			preKill(proc)
+		}
+		// The hook may have ended the process itself.
+		if err := proc.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return KillProcessError(err)
+		}
+		// Reap the killed process, so it is not left a zombie with a
+		// goroutine stuck waiting on it.
+		select {
+		case <-result:
+		case <-time.After(killWait):
 		}
 		return ProcStopTimeoutError
 	}
 }
 
-func start(cmd commander) (ioPipe, error) {
+func start(cmd commander) (*ioPipe, error) {
 	in, err := cmd.StdinPipe()
 	if err != nil {
-		return ioPipe{}, err
+		return nil, err
 	}
 	defer func() {
 		if err != nil {
@@ -160,19 +581,36 @@ func start(cmd commander) (ioPipe, error) {
 	}()
 	out, err := cmd.StdoutPipe()
 	if err != nil {
-		return ioPipe{}, err
+		return nil, err
 	}
 	defer func() {
 		if err != nil {
 			out.Close()
 		}
 	}()
+	if e, ok := cmd.(execCmd); ok && e.pipeSize > 0 {
+		if f, ok := out.(*os.File); ok {
+			if err = setPipeSize(f, e.pipeSize); err != nil {
+				return nil, PipeSizeError(e.pipeSize, err)
+			}
+		}
+	}
+	var stderr io.ReadCloser
+	if e, ok := cmd.(execCmd); ok && e.captureStderr {
+		if stderr, err = e.StderrPipe(); err != nil {
+			return nil, err
+		}
+	}
 
 	proc, err := cmd.Start()
 	if err != nil {
-		return ioPipe{}, err
+		return nil, err
 	}
-	return ioPipe{out, in, proc}, nil
+	pipe := &ioPipe{ReadCloser: out, WriteCloser: in, proc: proc, exit: new(exitStatus), stderr: stderr}
+	if e, ok := cmd.(execCmd); ok {
+		pipe.pid = e.Process.Pid
+	}
+	return pipe, nil
 }
 
 type rwCloser struct {
@@ -180,6 +618,12 @@ type rwCloser struct {
 	io.WriteCloser
 }
 
+type readWriteCloser struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
 func rwc(r io.ReadCloser, w io.WriteCloser) rwCloser {
 	return rwCloser{r, w}
 }
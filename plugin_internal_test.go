@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartWithStdin(t *testing.T) {
+	path, args := helperProcess(t, "stdin")
+	client, err := StartWithStdin(strings.NewReader(`{"greeting":"hello"}`), os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Config.Get", "greeting", &reply); err != nil || reply != "hello" {
+		t.Errorf("expected config echoed back, got %q, %v", reply, err)
+	}
+	if err := client.Call("Test.Echo", "rpc", &reply); err != nil || reply != "rpc" {
+		t.Errorf("expected rpc to work after config, got %q, %v", reply, err)
+	}
+}
+
+func TestPluginPrintsToStdout(t *testing.T) {
+	var stderr bytes.Buffer
+	path, args := helperProcess(t, "print")
+	client, err := Start(&stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Test.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Errorf("expected call to survive plugin printing, got %q, %v", reply, err)
+	}
+	client.Close()
+	if !strings.Contains(stderr.String(), "printed by plugin: hello") {
+		t.Errorf("expected printed output on stderr, got %q", stderr.String())
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	go p.ServeJSON()
+	client := jsonrpc.NewClient(host)
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Test.Echo", "json", &reply); err != nil || reply != "json" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestStartJSON(t *testing.T) {
+	path, args := helperProcess(t, "json")
+	client, err := StartJSON(os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Test.Echo", "json", &reply); err != nil || reply != "json" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
+
+func TestCloseAbnormalExit(t *testing.T) {
+	path, args := helperProcess(t, "exit3")
+	pipe, err := start(makeCommand(nil, path, args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, pipe)
+	var exitErr *AbnormalExitError
+	if err := pipe.Close(); !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("expected AbnormalExitError with code 3, got %v", err)
+	}
+
+	path, args = helperProcess(t, "echo")
+	pipe, err = start(makeCommand(nil, path, args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pipe.Close(); err != nil {
+		t.Errorf("expected orderly close to succeed, got %v", err)
+	}
+}
+
+func TestStartWithDeadline(t *testing.T) {
+	cmd := &fakeCmd{api: newTestAPI(), block: make(chan struct{}), started: make(chan *fakeProc, 1)}
+	orig := makeCommand
+	makeCommand = func(io.Writer, string, []string) commander { return cmd }
+	defer func() { makeCommand = orig }()
+
+	if _, err := StartWithDeadline(time.Now().Add(20*time.Millisecond), nil, "slow"); err != ErrStartTimeout {
+		t.Fatalf("expected ErrStartTimeout, got %v", err)
+	}
+	close(cmd.block)
+	select {
+	case <-(<-cmd.started).done:
+	case <-time.After(time.Second):
+		t.Error("expected the late process to be killed")
+	}
+}
+
+func TestServeFlushesLastReply(t *testing.T) {
+	path, args := helperProcess(t, "delay")
+	pipe, err := start(makeCommand(os.Stderr, path, args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.Close()
+	codec := newGobClientCodec(pipe, 0, 0)
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "Test.Echo", Seq: 1}, "last"); err != nil {
+		t.Fatal(err)
+	}
+	// Hang up straight away: the plugin stops reading, but must still write
+	// and flush the reply in flight before it exits.
+	pipe.WriteCloser.Close()
+
+	var resp rpc.Response
+	var reply string
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatalf("expected the reply before the plugin exited, got %v", err)
+	}
+	if err := codec.ReadResponseBody(&reply); err != nil || reply != "last" {
+		t.Errorf("unexpected reply %q, %v", reply, err)
+	}
+}
+
+// batchCodec holds replies in a buffer of its own until Flush, as a codec
+// batching writes would, and drops them if closed without one.
+type batchCodec struct {
+	rpc.ServerCodec
+	w    *bufio.Writer
+	conn io.Closer
+}
+
+func newBatchCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	w := bufio.NewWriterSize(conn, 1<<16)
+	return &batchCodec{newGobServerCodecSize(rwc(io.NopCloser(conn), nopWriteCloser{w}), 0, 0), w, conn}
+}
+
+func (c *batchCodec) Flush() error {
+	return c.w.Flush()
+}
+
+func (c *batchCodec) Close() error {
+	return c.conn.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestServeFlushesCodec(t *testing.T) {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	p := newPlugin("Test", "", rwc(reqR, respW), newTestAPI(), nil)
+	go p.ServeCodec(newBatchCodec)
+
+	codec := newGobClientCodec(rwc(respR, reqW), 0, 0)
+	if err := codec.WriteRequest(&rpc.Request{ServiceMethod: "Test.Echo", Seq: 1}, "last"); err != nil {
+		t.Fatal(err)
+	}
+	reqW.Close()
+
+	var resp rpc.Response
+	var reply string
+	if err := codec.ReadResponseHeader(&resp); err != nil {
+		t.Fatalf("expected the codec flushed before serving ended, got %v", err)
+	}
+	if err := codec.ReadResponseBody(&reply); err != nil || reply != "last" {
+		t.Errorf("unexpected reply %q, %v", reply, err)
+	}
+}
+
+func TestMainPanic(t *testing.T) {
+	path, args := helperProcess(t, "panic")
+	var stderr bytes.Buffer
+	pipe, err := start(makeCommand(&stderr, path, args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, pipe)
+	var exitErr *AbnormalExitError
+	if err := pipe.Close(); !errors.As(err, &exitErr) || !exitErr.Panicked() {
+		t.Fatalf("expected a panicked AbnormalExitError, got %v", err)
+	}
+	i := strings.Index(stderr.String(), crashMarker)
+	if i < 0 {
+		t.Fatalf("expected a crash record on stderr, got %q", stderr.String())
+	}
+	var record CrashRecord
+	line := strings.SplitN(stderr.String()[i+len(crashMarker):], "\n", 2)[0]
+	if err := json.Unmarshal([]byte(line), &record); err != nil || record.Panic != "boom" {
+		t.Errorf("unexpected crash record %+v, %v", record, err)
+	}
+}
@@ -0,0 +1,32 @@
+// Package plugintest runs plugin APIs in-process for tests, with no plugin
+// binary to build or subprocess to spawn.
+package plugintest
+
+import (
+	"net"
+	"net/rpc"
+	"reflect"
+
+	"github.com/thrisp/plugin"
+)
+
+// NewInProcess serves api as a plugin.Plugin over one end of a net.Pipe and
+// returns a client for the other end. As with rpc.Register, the service is
+// named for api's type. The returned cleanup closes both ends and waits for
+// Serve to return.
+func NewInProcess(api interface{}) (*rpc.Client, func()) {
+	name := reflect.Indirect(reflect.ValueOf(api)).Type().Name()
+	host, conn := net.Pipe()
+	p := plugin.NewFromConn(name, conn, api)
+	served := make(chan struct{})
+	go func() {
+		p.Serve()
+		close(served)
+	}()
+	client := rpc.NewClient(host)
+	return client, func() {
+		client.Close()
+		p.Close()
+		<-served
+	}
+}
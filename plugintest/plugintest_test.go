@@ -0,0 +1,33 @@
+package plugintest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+type Greeter struct{}
+
+func (Greeter) Greet(name string, reply *string) error {
+	*reply = "hello " + name
+	return nil
+}
+
+func TestNewInProcess(t *testing.T) {
+	before := runtime.NumGoroutine()
+	client, cleanup := NewInProcess(Greeter{})
+	var reply string
+	if err := client.Call("Greeter.Greet", "plugin", &reply); err != nil || reply != "hello plugin" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	cleanup()
+	if err := client.Call("Greeter.Greet", "plugin", &reply); err == nil {
+		t.Error("expected call after cleanup to fail")
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cleanup to stop serving, %d goroutines left over %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"sync"
+)
+
+// Pool runs several instances of the same plugin binary and spreads calls
+// across them round robin.
+type Pool struct {
+	path string
+	opts []Option
+
+	resizing sync.Mutex
+	mu       sync.Mutex
+	clients  []*Client
+	next     int
+	busy     map[*Client]int
+	idle     *sync.Cond
+	closed   bool
+}
+
+var PoolSizeError = Xrror("invalid plugin pool size %d").Out
+
+func NewPool(n int, path string, opts ...Option) (*Pool, error) {
+	p := &Pool{path: path, opts: opts, busy: make(map[*Client]int)}
+	p.idle = sync.NewCond(&p.mu)
+	for i := 0; i < n; i++ {
+		c, err := NewClient(path, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.clients = append(p.clients, c)
+	}
+	return p, nil
+}
+
+func (p *Pool) Clients() []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Client(nil), p.clients...)
+}
+
+func (p *Pool) Call(serviceMethod string, args, reply interface{}) error {
+	p.mu.Lock()
+	if p.closed || len(p.clients) == 0 {
+		p.mu.Unlock()
+		return ClientClosedError
+	}
+	c := p.clients[p.next%len(p.clients)]
+	p.next++
+	p.busy[c]++
+	p.mu.Unlock()
+	defer p.done(c)
+	return c.Call(serviceMethod, args, reply)
+}
+
+// hold marks every client busy, as Call does the one it picks, and returns
+// them; each must be given back with done.
+func (p *Pool) hold() []*Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.clients {
+		p.busy[c]++
+	}
+	return append([]*Client(nil), p.clients...)
+}
+
+func (p *Pool) done(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.busy[c]--; p.busy[c] == 0 {
+		delete(p.busy, c)
+		p.idle.Broadcast()
+	}
+}
+
+// Resize grows or shrinks the pool to n plugin instances. Growing starts
+// the extra instances, and fails without changing the pool if any of them
+// cannot be started. Shrinking takes the most recently added instances out
+// of rotation at once, then closes each when the pool's calls on it have
+// returned. A closed pool fails with ClientClosedError, and instances
+// started by a Resize the pool is closed during are closed again.
+func (p *Pool) Resize(n int) error {
+	if n < 0 {
+		return PoolSizeError(n)
+	}
+	p.resizing.Lock()
+	defer p.resizing.Unlock()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ClientClosedError
+	}
+	current := len(p.clients)
+	if n <= current {
+		removed := p.clients[n:]
+		p.clients = p.clients[:n:n]
+		for _, c := range removed {
+			for p.busy[c] > 0 {
+				p.idle.Wait()
+			}
+		}
+		p.mu.Unlock()
+		var err error
+		for _, c := range removed {
+			if closeErr := c.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	p.mu.Unlock()
+
+	added := make([]*Client, 0, n-current)
+	for len(added) < n-current {
+		c, err := NewClient(p.path, p.opts...)
+		if err != nil {
+			for _, c := range added {
+				c.Close()
+			}
+			return err
+		}
+		added = append(added, c)
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		for _, c := range added {
+			c.Close()
+		}
+		return ClientClosedError
+	}
+	p.clients = append(p.clients, added...)
+	p.mu.Unlock()
+	return nil
+}
+
+// Close closes every instance in rotation. Instances a Resize is taking
+// out are closed by the Resize once their calls return.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	clients := p.clients
+	p.clients = nil
+	p.mu.Unlock()
+	var err error
+	for _, c := range clients {
+		if closeErr := c.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// BroadcastPlugin sends a call to every member of a Pool. NewReply returns
+// a fresh pointer for each member's reply to be decoded into.
+type BroadcastPlugin struct {
+	*Pool
+	NewReply func() interface{}
+}
+
+func NewBroadcastPlugin(pool *Pool, newReply func() interface{}) *BroadcastPlugin {
+	return &BroadcastPlugin{Pool: pool, NewReply: newReply}
+}
+
+// Broadcast calls serviceMethod on all pool members concurrently, and
+// returns their replies and errors indexed as Clients. Every member is
+// called even when some fail, and, as with Call, a Resize does not close a
+// member until its call has returned.
+func (b *BroadcastPlugin) Broadcast(serviceMethod string, args interface{}) ([]interface{}, []error) {
+	clients := b.hold()
+	replies := make([]interface{}, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		replies[i] = b.NewReply()
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			defer b.done(c)
+			errs[i] = c.Call(serviceMethod, args, replies[i])
+		}(i, c)
+	}
+	wg.Wait()
+	return replies, errs
+}
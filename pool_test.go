@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingAPI struct {
+	calls *int32
+}
+
+func (c countingAPI) Hit(args string, reply *int32) error {
+	*reply = atomic.AddInt32(c.calls, 1)
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	calls := new(int32)
+	withFakePlugin(t, countingAPI{calls})
+	pool, err := NewPool(3, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	b := NewBroadcastPlugin(pool, func() interface{} { return new(int32) })
+	replies, errs := b.Broadcast("Test.Hit", "invalidate")
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("member %d: %s", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 3 || len(replies) != 3 {
+		t.Errorf("expected all 3 members called, got %d calls and %d replies", n, len(replies))
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	api := newTestAPI()
+	withFakePlugin(t, api)
+	pool, err := NewPool(2, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err := pool.Resize(4); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pool.Clients()); n != 4 {
+		t.Fatalf("expected 4 clients, got %d", n)
+	}
+	var reply string
+	for i := 0; i < 3; i++ {
+		if err := pool.Call("Test.Echo", "hi", &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fourth call goes to the newest client, which shrinking removes.
+	var slow string
+	inflight := make(chan error, 1)
+	go func() { inflight <- pool.Call("Test.Slow", "in flight", &slow) }()
+	<-api.started
+
+	resized := make(chan error, 1)
+	go func() { resized <- pool.Resize(2) }()
+	select {
+	case err := <-resized:
+		t.Fatalf("expected Resize to wait for the in-flight call, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if n := len(pool.Clients()); n != 2 {
+		t.Errorf("expected removed clients out of rotation at once, got %d", n)
+	}
+	close(api.release)
+	if err := <-inflight; err != nil || slow != "in flight" {
+		t.Errorf("expected the in-flight call to complete, got %q, %v", slow, err)
+	}
+	if err := <-resized; err != nil {
+		t.Errorf("unexpected resize error: %s", err)
+	}
+	if err := pool.Resize(-1); err == nil {
+		t.Error("expected an error for a negative size")
+	}
+}
+
+func TestPoolBroadcastHoldsMembers(t *testing.T) {
+	api := newTestAPI()
+	withFakePlugin(t, api)
+	pool, err := NewPool(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	b := NewBroadcastPlugin(pool, func() interface{} { return new(string) })
+	broadcast := make(chan []error, 1)
+	go func() {
+		_, errs := b.Broadcast("Test.Slow", "in flight")
+		broadcast <- errs
+	}()
+	<-api.started
+	resized := make(chan error, 1)
+	go func() { resized <- pool.Resize(0) }()
+	select {
+	case err := <-resized:
+		t.Fatalf("expected Resize to wait for the broadcast, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(api.release)
+	if errs := <-broadcast; errs[0] != nil {
+		t.Errorf("expected the broadcast call to complete, got %v", errs[0])
+	}
+	if err := <-resized; err != nil {
+		t.Errorf("unexpected resize error: %s", err)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	withFakePlugin(t, newTestAPI())
+	pool, err := NewPool(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Resize(2); err != ClientClosedError {
+		t.Errorf("expected Resize of a closed pool to fail, got %v", err)
+	}
+	if n := len(pool.Clients()); n != 0 {
+		t.Errorf("expected a closed pool to stay empty, got %d clients", n)
+	}
+	var reply string
+	if err := pool.Call("Test.Echo", "hi", &reply); err != ClientClosedError {
+		t.Errorf("expected ClientClosedError, got %v", err)
+	}
+	if err := pool.Close(); err != nil {
+		t.Errorf("expected a second Close to do nothing, got %v", err)
+	}
+}
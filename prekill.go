@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"os"
+	"time"
+)
+
+// ProcessSignaler is the plugin process as a PreKillHook sees it.
+type ProcessSignaler interface {
+	Signal(os.Signal) error
+}
+
+// PreKillHook runs just before a plugin that did not stop when interrupted
+// is killed, to gather diagnostics from it while it is still alive.
+type PreKillHook func(proc ProcessSignaler)
+
+// WithPreKillHook runs hook before Close kills a plugin that outlived
+// WithKillTimeout, giving it at most timeout before the kill goes ahead
+// regardless. A hook may, for example, send a Go plugin SIGQUIT, which
+// dumps every goroutine's stack to the plugin's standard error, and sleep
+// briefly while the dump is written.
+func WithPreKillHook(timeout time.Duration, hook PreKillHook) Option {
+	return func(c *config) {
+		c.preKill = func(proc osProcess) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				hook(proc)
+			}()
+			select {
+			case <-done:
+			case <-time.After(timeout):
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix && !windows
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(*exec.Cmd) {}
+
+func newProcessGroup(proc osProcess, pid int) osProcess {
+	return proc
+}
+
+// exitSignal is always nil, as processes here are not ended by signals.
+func exitSignal(*os.ProcessState) os.Signal {
+	return nil
+}
+
+// ignoreBrokenPipe does nothing, as there is no SIGPIPE here.
+func ignoreBrokenPipe() {}
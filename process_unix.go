@@ -0,0 +1,57 @@
+//go:build unix
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// setProcessGroup starts the plugin as the leader of a new process group so
+// that anything it spawns can be signalled along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+type processGroup struct {
+	osProcess
+	pgid int
+}
+
+func newProcessGroup(proc osProcess, pid int) osProcess {
+	return processGroup{proc, pid}
+}
+
+func (g processGroup) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return g.osProcess.Signal(sig)
+	}
+	if err := syscall.Kill(-g.pgid, s); err != syscall.ESRCH {
+		return err
+	}
+	return os.ErrProcessDone
+}
+
+func (g processGroup) Kill() error {
+	return syscall.Kill(-g.pgid, syscall.SIGKILL)
+}
+
+func exitSignal(state *os.ProcessState) os.Signal {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal()
+	}
+	return nil
+}
+
+// ignoreBrokenPipe stops writes to a standard output or error whose reader
+// has gone from killing the process with SIGPIPE, so they fail with EPIPE
+// instead.
+func ignoreBrokenPipe() {
+	signal.Ignore(syscall.SIGPIPE)
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package plugin
+
+import (
+	"encoding/gob"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func processGone(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return true
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	return err == nil && strings.Contains(string(stat), ") Z ")
+}
+
+func TestCloseKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	path, args := helperProcess(t, "grandchild")
+	client, err := Start(os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	if err := client.Call("Test.Pid", 0, &pid); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("grandchild %d outlived the plugin", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMainIgnoresBrokenPipe(t *testing.T) {
+	path, args := helperProcess(t, "main")
+	cmd := exec.Command(path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// The host goes away before reading the reply to its last call.
+	stdout.Close()
+	enc := gob.NewEncoder(stdin)
+	enc.Encode(rpc.Request{ServiceMethod: "Test.Echo", Seq: 1})
+	enc.Encode("unread")
+	stdin.Close()
+
+	cmd.Wait()
+	if state := cmd.ProcessState; !state.Exited() || state.ExitCode() != 0 {
+		t.Errorf("expected the plugin to exit cleanly, got %s", state)
+	}
+}
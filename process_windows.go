@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+	jobObjectLimitKillOnJobClose           = 0x2000
+	processSetQuota                        = 0x0100
+	processTerminate                       = 0x0001
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                [6]uint64
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// processGroup places the plugin in a job object that kills every process
+// in it when the job is terminated or its last handle closed. Descendants
+// spawned before the plugin is assigned to the job are not covered.
+type processGroup struct {
+	osProcess
+	job syscall.Handle
+}
+
+func newProcessGroup(proc osProcess, pid int) osProcess {
+	job, err := assignJobObject(pid)
+	if err != nil {
+		return proc
+	}
+	return processGroup{proc, job}
+}
+
+func assignJobObject(pid int) (syscall.Handle, error) {
+	r, _, err := procCreateJobObjectW.Call(0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	job := syscall.Handle(r)
+	var info jobObjectExtendedLimitInformation
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	r, _, err = procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformationClass,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	proc, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(pid))
+	if err != nil {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	defer syscall.CloseHandle(proc)
+	if r, _, err = procAssignProcessToJobObject.Call(uintptr(job), uintptr(proc)); r == 0 {
+		syscall.CloseHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
+
+func (g processGroup) Kill() error {
+	if r, _, err := procTerminateJobObject.Call(uintptr(g.job), 1); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (g processGroup) Wait() (*os.ProcessState, error) {
+	state, err := g.osProcess.Wait()
+	syscall.CloseHandle(g.job)
+	return state, err
+}
+
+// exitSignal is always nil, as processes here are not ended by signals.
+func exitSignal(*os.ProcessState) os.Signal {
+	return nil
+}
+
+// ignoreBrokenPipe does nothing, as there is no SIGPIPE here.
+func ignoreBrokenPipe() {}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+)
+
+var RateLimitedError = Xrror("plugin rate limit exceeded")
+
+// WithRateLimit limits the calls a plugin serves to rps per second, with
+// bursts of up to burst calls. Calls over the limit wait for their turn
+// before running, or fail with RateLimitedError if that would take
+// longer than the RateLimitWait deadline.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(c *config) {
+		c.rateLimit = rps
+		c.rateBurst = burst
+	}
+}
+
+// RateLimitWait bounds how long a call waits on WithRateLimit. By default
+// calls wait as long as it takes.
+func RateLimitWait(d time.Duration) Option {
+	return func(c *config) {
+		c.rateWait = d
+	}
+}
+
+// WithCallbackRateLimit limits the calls a plugin started with NewBiDi can
+// make back into the host to rps per second, with bursts of up to burst
+// calls. Calls over the limit are rejected straight away with
+// RateLimitedError rather than queued, so a runaway plugin cannot tie up
+// the host.
+func WithCallbackRateLimit(rps float64, burst int) Option {
+	return func(c *config) {
+		c.callbackRateLimit = rps
+		c.callbackRateBurst = burst
+	}
+}
+
+// rateLimiter is a token bucket holding up to burst tokens and refilling
+// at rate tokens per second. A nil limiter lets every call through.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	maxWait time.Duration
+	reject  bool
+}
+
+func (c *config) rateLimiter() *rateLimiter {
+	return newRateLimiter(c.rateLimit, c.rateBurst, c.rateWait)
+}
+
+func (c *config) callbackRateLimiter() *rateLimiter {
+	l := newRateLimiter(c.callbackRateLimit, c.callbackRateBurst, 0)
+	if l != nil {
+		l.reject = true
+	}
+	return l
+}
+
+func newRateLimiter(rps float64, burst int, maxWait time.Duration) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now(), maxWait: maxWait}
+}
+
+func (l *rateLimiter) wait() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	var delay time.Duration
+	if l.tokens < 1 {
+		delay = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if l.reject && delay > 0 || l.maxWait > 0 && delay > l.maxWait {
+		l.mu.Unlock()
+		return RateLimitedError
+	}
+	l.tokens--
+	l.mu.Unlock()
+	time.Sleep(delay)
+	return nil
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	host, conn := net.Pipe()
+	go NewFromConn("Test", conn, newTestAPI(), WithRateLimit(10, 2), RateLimitWait(20*time.Millisecond)).Serve()
+	client := NewClientFromConn(host)
+	defer client.Close()
+
+	var ok, limited int
+	for i := 0; i < 6; i++ {
+		var reply string
+		switch err := client.Call("Test.Echo", "hi", &reply); {
+		case err == nil:
+			ok++
+		case errors.Is(err, RateLimitedError):
+			limited++
+		default:
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if ok != 2 || limited != 4 {
+		t.Errorf("expected the burst of 2 calls through and 4 limited, got %d and %d", ok, limited)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	var reply string
+	if err := client.Call("Test.Echo", "hi", &reply); err != nil {
+		t.Errorf("expected a call once tokens refilled, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"os"
+	"time"
+)
+
+const (
+	// readyMarker is the line Ready writes ahead of the RPC stream.
+	readyMarker = "PLUGIN-READY\n"
+	// readyEnv tells a plugin started by StartReady to write it.
+	readyEnv = "PLUGIN_READY"
+)
+
+var NotReadyError = Xrror("plugin did not signal readiness within %s").Out
+
+// Ready tells a host waiting in StartReady that the plugin has finished
+// initialising, and should be called just before Serve. A plugin started
+// any other way, by NewClient, whose handshake already tells the host the
+// plugin is serving, or by Start, whose host expects nothing, may call it
+// too, and for them it does nothing.
+func (p *Plugin) Ready() error {
+	if !p.ready || p.handshake {
+		return nil
+	}
+	_, err := io.WriteString(p.ReadWriteCloser, readyMarker)
+	return err
+}
+
+// StartReady is Start waiting up to timeout for the plugin to call Ready
+// before returning the client, so the first call is not made while the
+// plugin is still initialising. If the plugin does not become ready in
+// time it is stopped and NotReadyError returned.
+func StartReady(timeout time.Duration, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	cmd := makeCommand(output, path, args)
+	if e, ok := cmd.(execCmd); ok {
+		e.Env = append(os.Environ(), readyEnv+"=1")
+	}
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, len(readyMarker))
+		if _, err := io.ReadFull(pipe, b); err != nil {
+			ready <- err
+		} else if string(b) != readyMarker {
+			ready <- HandshakeError(b)
+		}
+		close(ready)
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			pipe.Close()
+			return nil, err
+		}
+		return newClient(pipe, defaultMaxMessageSize), nil
+	case <-time.After(timeout):
+		// Closing the pipe ends the read the reader is blocked in.
+		pipe.Close()
+		<-ready
+		return nil, NotReadyError(timeout)
+	}
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartReady(t *testing.T) {
+	path, args := helperProcess(t, "ready")
+	client, err := StartReady(5*time.Second, os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Test.Echo", "ready", &reply); err != nil || reply != "ready" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	client.Close()
+
+	// Started without StartReady, the plugin's Ready must not write the
+	// marker into the RPC stream.
+	client, err = Start(os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Test.Echo", "plain", &reply); err != nil || reply != "plain" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	client.Close()
+
+	path, args = helperProcess(t, "echo")
+	if _, err := StartReady(100*time.Millisecond, os.Stderr, path, args...); err == nil || !strings.Contains(err.Error(), "readiness") {
+		t.Errorf("expected NotReadyError from a plugin that never calls Ready, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import "sync"
+
+var PluginNotRegisteredError = Xrror("no plugin registered as %s").Out
+
+// PluginRegistry creates plugins by name on first use, from factories
+// registered ahead of time, and keeps them until StopAll.
+type PluginRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	factory func() (*Plugin, error)
+
+	mu     sync.Mutex
+	plugin *Plugin
+}
+
+func NewPluginRegistry() *PluginRegistry {
+	return &PluginRegistry{entries: make(map[string]*registryEntry)}
+}
+
+// Register sets the factory for name, replacing any earlier one. A plugin
+// already created from the earlier factory is kept until StopAll.
+func (r *PluginRegistry) Register(name string, factory func() (*Plugin, error)) {
+	r.mu.Lock()
+	e, ok := r.entries[name]
+	if !ok {
+		r.entries[name] = &registryEntry{factory: factory}
+	}
+	r.mu.Unlock()
+	if ok {
+		// The entry's factory may be running; wait for it without
+		// holding up the rest of the registry.
+		e.mu.Lock()
+		e.factory = factory
+		e.mu.Unlock()
+	}
+}
+
+// Get returns the plugin name, calling its factory the first time. Callers
+// asking for the same plugin while its factory runs wait for it rather than
+// calling it again. A factory that fails is called again on the next Get.
+func (r *PluginRegistry) Get(name string) (*Plugin, error) {
+	r.mu.Lock()
+	e, ok := r.entries[name]
+	r.mu.Unlock()
+	if !ok {
+		return nil, PluginNotRegisteredError(name)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.plugin == nil {
+		p, err := e.factory()
+		if err != nil {
+			return nil, err
+		}
+		e.plugin = p
+	}
+	return e.plugin, nil
+}
+
+// StopAll closes every plugin created so far, returning the first error.
+// Their factories stay registered, so a later Get creates them afresh.
+func (r *PluginRegistry) StopAll() error {
+	r.mu.Lock()
+	entries := make([]*registryEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+	var err error
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.plugin != nil {
+			if closeErr := e.plugin.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			e.plugin = nil
+		}
+		e.mu.Unlock()
+	}
+	return err
+}
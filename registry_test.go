@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPluginRegistry(t *testing.T) {
+	r := NewPluginRegistry()
+	var calls int32
+	r.Register("test", func() (*Plugin, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		_, conn := net.Pipe()
+		return NewFromConn("Test", conn, newTestAPI()), nil
+	})
+
+	plugins := make([]*Plugin, 2)
+	var wg sync.WaitGroup
+	for i := range plugins {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := r.Get("test")
+			if err != nil {
+				t.Error(err)
+			}
+			plugins[i] = p
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 || plugins[0] != plugins[1] {
+		t.Errorf("expected one factory call shared by both, got %d calls", n)
+	}
+
+	if _, err := r.Get("missing"); err == nil {
+		t.Error("expected an error for an unregistered plugin")
+	}
+	if err := r.StopAll(); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := r.Get("test"); err != nil || p == plugins[0] || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected a fresh plugin after StopAll, got %v", err)
+	}
+}
+
+func TestPluginRegistrySlowFactory(t *testing.T) {
+	r := NewPluginRegistry()
+	release := make(chan struct{})
+	factory := func() (*Plugin, error) {
+		<-release
+		_, conn := net.Pipe()
+		return NewFromConn("Test", conn, newTestAPI()), nil
+	}
+	r.Register("slow", factory)
+	r.Register("other", func() (*Plugin, error) {
+		_, conn := net.Pipe()
+		return NewFromConn("Test", conn, newTestAPI()), nil
+	})
+	defer r.StopAll()
+
+	go r.Get("slow")
+	time.Sleep(10 * time.Millisecond)
+	go r.Register("slow", factory)
+	go r.StopAll()
+	time.Sleep(10 * time.Millisecond)
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := r.Get("other")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected other plugins to be available while a factory runs")
+	}
+	close(release)
+}
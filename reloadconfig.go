@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/rpc"
+)
+
+// configService is the reserved service through which hosts hand a running
+// plugin new configuration.
+const configService = "__Config"
+
+var NotConfigReloaderError = Xrror("plugin API does not implement ConfigReloader")
+
+// ConfigReloader is implemented by plugin APIs that can apply new
+// configuration without restarting. Configuration sent by the host with
+// ReloadConfig arrives as a json.RawMessage, to be unmarshalled into the
+// plugin's own configuration type.
+type ConfigReloader interface {
+	ReloadConfig(cfg interface{}) error
+}
+
+// ReloadConfig applies cfg to the plugin's API, the service registered
+// under the plugin's service name, if it implements ConfigReloader.
+func (p *Plugin) ReloadConfig(cfg interface{}) error {
+	r, ok := p.dispatch.receiver(p.service).(ConfigReloader)
+	if !ok {
+		return NotConfigReloaderError
+	}
+	return r.ReloadConfig(cfg)
+}
+
+// ReloadConfig marshals cfg to JSON and sends it to the plugin client
+// talks to, which must serve a ConfigReloader API.
+func ReloadConfig(client *rpc.Client, cfg interface{}) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return client.Call(configService+".Reload", data, new(struct{}))
+}
+
+// configReloader serves Plugin.ReloadConfig over RPC.
+type configReloader struct {
+	p *Plugin
+}
+
+func (c configReloader) Reload(data []byte, reply *struct{}) error {
+	return c.p.ReloadConfig(json.RawMessage(data))
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+type limitsConfig struct {
+	MaxItems int
+	Mode     string
+}
+
+type configuredAPI struct {
+	mu  sync.Mutex
+	cfg limitsConfig
+}
+
+func (c *configuredAPI) Mode(args int, reply *string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*reply = c.cfg.Mode
+	return nil
+}
+
+func (c *configuredAPI) ReloadConfig(cfg interface{}) error {
+	var next limitsConfig
+	switch cfg := cfg.(type) {
+	case json.RawMessage:
+		if err := json.Unmarshal(cfg, &next); err != nil {
+			return err
+		}
+	case limitsConfig:
+		next = cfg
+	default:
+		return Xrror("unexpected config type")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = next
+	return nil
+}
+
+func TestReloadConfig(t *testing.T) {
+	api := &configuredAPI{cfg: limitsConfig{Mode: "strict"}}
+	p, client := NewInProcess("Test", api)
+	defer client.Close()
+
+	if err := ReloadConfig(client, limitsConfig{MaxItems: 10, Mode: "lenient"}); err != nil {
+		t.Fatal(err)
+	}
+	var mode string
+	if err := client.Call("Test.Mode", 0, &mode); err != nil || mode != "lenient" {
+		t.Errorf("expected the reloaded config to apply, got %q, %v", mode, err)
+	}
+	if api.cfg.MaxItems != 10 {
+		t.Errorf("expected MaxItems 10, got %d", api.cfg.MaxItems)
+	}
+
+	if err := p.ReloadConfig(limitsConfig{Mode: "local"}); err != nil || api.cfg.Mode != "local" {
+		t.Errorf("expected the local reload to apply, got %q, %v", api.cfg.Mode, err)
+	}
+	if err := ReloadConfig(client, "not an object"); err == nil {
+		t.Error("expected the plugin's unmarshal error to be returned")
+	}
+	if m, err := ListMethods(client); err != nil || len(m.Services) != 1 {
+		t.Errorf("expected __Config to be left out of the methods listed, got %+v, %v", m, err)
+	}
+}
+
+func TestReloadConfigUnsupported(t *testing.T) {
+	p, client := NewInProcess("Test", newTestAPI())
+	defer client.Close()
+	if err := p.ReloadConfig(limitsConfig{}); err != NotConfigReloaderError {
+		t.Errorf("expected NotConfigReloaderError, got %v", err)
+	}
+	if err := ReloadConfig(client, limitsConfig{}); err == nil {
+		t.Error("expected an error reloading a plugin without ConfigReloader")
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	Multiplier   float64
+	Jitter       float64
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialDelay)
+	for i := 0; i < attempt && p.Multiplier > 0; i++ {
+		d *= p.Multiplier
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// Retry calls call until it succeeds, returns an error that is not a
+// transient transport failure, or policy.MaxAttempts calls have been made.
+func Retry(call func() error, policy RetryPolicy) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = call(); err == nil || !isTransientRPCError(err) {
+			return err
+		}
+		if attempt+1 >= policy.MaxAttempts {
+			return err
+		}
+		time.Sleep(policy.delay(attempt))
+	}
+}
+
+// isTransientRPCError reports whether err is a dropped connection or a
+// network timeout, which a fresh call may get past. net.Error's Temporary
+// is deprecated and unreliable, so only Timeout is consulted.
+func isTransientRPCError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, rpc.ErrShutdown) || errors.Is(err, PluginClosedError) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
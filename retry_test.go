@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := Retry(func() error {
+		calls++
+		if calls < 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	}, RetryPolicy{MaxAttempts: 5, Multiplier: 2, Jitter: 0.5})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	calls := 0
+	perm := Xrror("permanent")
+	err := Retry(func() error { calls++; return perm }, RetryPolicy{MaxAttempts: 5})
+	if err != perm || calls != 1 {
+		t.Errorf("expected one call returning %v, got %d calls returning %v", perm, calls, err)
+	}
+}
+
+func TestIsTransientRPCError(t *testing.T) {
+	for err, transient := range map[error]bool{
+		io.EOF:                                  true,
+		rpc.ErrShutdown:                         true,
+		fmt.Errorf("call: %w", rpc.ErrShutdown): true,
+		&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}: true,
+		&net.OpError{Op: "dial", Err: os.ErrPermission}:       false,
+		rpc.ServerError("bad argument"):                       false,
+	} {
+		if got := isTransientRPCError(err); got != transient {
+			t.Errorf("expected %v to be transient %v, got %v", err, transient, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// seccompShimEnv carries the profile to the shim SandboxedStart runs.
+const seccompShimEnv = "PLUGIN_SECCOMP_SHIM"
+
+var SeccompNotSupportedError = Xrror("seccomp filtering is not supported on this platform")
+
+// SeccompProfile lists the system call numbers a restricted plugin may make.
+// Any other system call kills the plugin process. Numbers are specific to the
+// plugin's architecture, and must cover what the Go runtime itself needs.
+type SeccompProfile struct {
+	Allow []uintptr
+}
+
+func (s SeccompProfile) encode() string {
+	nrs := make([]string, len(s.Allow))
+	for i, nr := range s.Allow {
+		nrs[i] = strconv.FormatUint(uint64(nr), 10)
+	}
+	return strings.Join(nrs, ",")
+}
+
+func decodeSeccompProfile(spec string) (SeccompProfile, error) {
+	var s SeccompProfile
+	for _, field := range strings.Split(spec, ",") {
+		nr, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return s, err
+		}
+		s.Allow = append(s.Allow, uintptr(nr))
+	}
+	return s, nil
+}
+
+// SandboxedStart is Start with the plugin confined to the system calls in
+// profile. Go's SysProcAttr has no seccomp field, so the host re-executes
+// its own binary as a shim that installs the filter and then execs the
+// plugin: the filter is in place before any of the plugin's code runs and
+// holds whatever the plugin does. As the shim execs under the filter,
+// profile must allow execve as well as what the Go runtime needs. Where
+// seccomp is unavailable a warning is logged and the plugin is started
+// unrestricted, as by Start.
+func SandboxedStart(profile SeccompProfile, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	if !seccompSupported {
+		log.Printf("plugin: seccomp is not supported on %s/%s, starting %s unrestricted", runtime.GOOS, runtime.GOARCH, path)
+		return Start(output, path, args...)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := makeCommand(output, self, append([]string{path}, args...))
+	if e, ok := cmd.(execCmd); ok {
+		e.Env = append(os.Environ(), seccompShimEnv+"="+profile.encode())
+	}
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(pipe, defaultMaxMessageSize), nil
+}
@@ -0,0 +1,94 @@
+//go:build linux && (amd64 || arm64)
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	seccompSupported = true
+
+	prSetNoNewPrivs        = 38
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetAllow        = 0x7fff0000
+	seccompRetKillProcess  = 0x80000000
+	seccompDataNrOffset    = 0
+	seccompDataArchOffset  = 4
+	bpfLdWAbs              = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJeqK                = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfRetK                = syscall.BPF_RET | syscall.BPF_K
+)
+
+func seccompFilter(profile SeccompProfile) []syscall.SockFilter {
+	filter := []syscall.SockFilter{
+		{Code: bpfLdWAbs, K: seccompDataArchOffset},
+		{Code: bpfJeqK, Jt: 1, K: auditArch},
+		{Code: bpfRetK, K: seccompRetKillProcess},
+		{Code: bpfLdWAbs, K: seccompDataNrOffset},
+	}
+	for _, nr := range profile.Allow {
+		filter = append(filter,
+			syscall.SockFilter{Code: bpfJeqK, Jf: 1, K: uint32(nr)},
+			syscall.SockFilter{Code: bpfRetK, K: seccompRetAllow},
+		)
+	}
+	return append(filter, syscall.SockFilter{Code: bpfRetK, K: seccompRetKillProcess})
+}
+
+func applySeccomp(profile SeccompProfile) error {
+	filter := seccompFilter(profile)
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errno
+	}
+	_, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// When SandboxedStart runs this binary as its shim, it confines itself and
+// execs the plugin before main, or anything else the host does, runs.
+func init() {
+	if spec := os.Getenv(seccompShimEnv); spec != "" {
+		err := seccompShim(spec, os.Args[1:])
+		fmt.Fprintf(os.Stderr, "plugin: seccomp shim: %s\n", err)
+		os.Exit(126)
+	}
+}
+
+// seccompShim installs the filter in spec and execs args, returning only if
+// either fails.
+func seccompShim(spec string, args []string) error {
+	profile, err := decodeSeccompProfile(spec)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return exec.ErrNotFound
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, seccompShimEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	runtime.LockOSThread()
+	if err := applySeccomp(profile); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, env)
+}
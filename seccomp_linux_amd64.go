@@ -0,0 +1,7 @@
+package plugin
+
+const (
+	sysMemfdCreate = 319
+	sysSeccomp     = 317
+	auditArch      = 0xc000003e
+)
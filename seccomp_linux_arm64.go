@@ -0,0 +1,7 @@
+package plugin
+
+const (
+	sysMemfdCreate = 279
+	sysSeccomp     = 277
+	auditArch      = 0xc00000b7
+)
@@ -0,0 +1,9 @@
+//go:build !(linux && (amd64 || arm64))
+
+package plugin
+
+const seccompSupported = false
+
+func applySeccomp(SeccompProfile) error {
+	return SeccompNotSupportedError
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSandboxedStart(t *testing.T) {
+	if !seccompSupported {
+		t.Skip(SeccompNotSupportedError)
+	}
+	var profile SeccompProfile
+	for nr := uintptr(0); nr < 1024; nr++ {
+		if nr != syscall.SYS_GETPPID {
+			profile.Allow = append(profile.Allow, nr)
+		}
+	}
+	path, args := helperProcess(t, "seccomp")
+	client, err := SandboxedStart(profile, os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("Test.Echo", "allowed", &reply); err != nil {
+		t.Fatalf("allowed call failed: %s", err)
+	}
+	var ppid int
+	if err := client.Call("Test.Getppid", 0, &ppid); err == nil {
+		t.Errorf("expected disallowed syscall to kill the plugin, got ppid %d", ppid)
+	}
+}
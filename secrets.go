@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// secretFDEnv prefixes the environment variables naming the descriptor
+// each secret passed by StartWithEnvSecrets can be read from.
+const secretFDEnv = "PLUGIN_SECRET_FD_"
+
+var (
+	SecretNotFoundError      = Xrror("plugin secret %s was not passed by the host").Out
+	SecretsNotSupportedError = Xrror("plugin secrets need a plugin started as a local process")
+)
+
+// StartWithEnvSecrets is Start passing each of secrets to the plugin
+// through an inherited file descriptor rather than its environment, which
+// other processes of the same user can read through /proc/<pid>/environ.
+// Each secret is held in an anonymous memfd on Linux and an unlinked
+// temporary file elsewhere, and read by the plugin with ReadSecret. Only
+// the descriptor number appears in the environment.
+func StartWithEnvSecrets(secrets map[string]string, output io.Writer, path string, args ...string) (*rpc.Client, error) {
+	cmd, ok := makeCommand(output, path, args).(execCmd)
+	if !ok {
+		return nil, SecretsNotSupportedError
+	}
+	keys := make([]string, 0, len(secrets))
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	env := os.Environ()
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, key := range keys {
+		f, err := secretFile(key, secrets[key])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		fd := 3 + len(cmd.ExtraFiles)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		env = append(env, secretFDEnv+key+"="+strconv.Itoa(fd))
+	}
+	cmd.Env = env
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(pipe, defaultMaxMessageSize), nil
+}
+
+// initService is the reserved service through which a client created
+// WithSecrets hands the plugin its secrets. Only plugins created with New
+// serve it.
+const initService = "__Init"
+
+var InitRejectedError = Xrror("plugin secrets can only be sent once, before any other call")
+
+// WithSecrets has the client send secrets to the plugin over the RPC
+// connection, keeping them out of its arguments and environment. They are
+// sent each time the plugin is launched, once the handshake is done and
+// before any other call, and read by the plugin with ReadSecret. A plugin
+// therefore has no secrets yet while it initialises and signals readiness;
+// it may rely on them only in the calls it serves.
+func WithSecrets(secrets map[string]string) Option {
+	return func(c *config) {
+		c.secrets = secrets
+	}
+}
+
+func sendSecrets(client *rpc.Client, secrets map[string]string) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	return client.Call(initService+".Init", secrets, new(struct{}))
+}
+
+// initializer receives the secrets sent by WithSecrets. It accepts them
+// once, before the plugin has served a call to any service of its own, so
+// that nothing the host did not send at launch can replace them.
+type initializer struct {
+	d    *dispatcher
+	done int32
+}
+
+func (i *initializer) Init(secrets map[string]string, reply *struct{}) error {
+	if i.d.served() || !atomic.CompareAndSwapInt32(&i.done, 0, 1) {
+		return InitRejectedError
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for key, secret := range secrets {
+		secretsSent[key] = secret
+	}
+	return nil
+}
+
+var (
+	secretsMu    sync.Mutex
+	secretsFiles = make(map[string]*os.File)
+	secretsSent  = make(map[string]string)
+)
+
+// ReadSecret returns the secret key passed to the plugin by
+// StartWithEnvSecrets or WithSecrets; the latter only reach plugins created
+// with New. It may be called any number of times.
+func ReadSecret(key string) (string, error) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if secret, ok := secretsSent[key]; ok {
+		return secret, nil
+	}
+	f, ok := secretsFiles[key]
+	if !ok {
+		fd, err := strconv.Atoi(os.Getenv(secretFDEnv + key))
+		if err != nil {
+			return "", SecretNotFoundError(key)
+		}
+		f = os.NewFile(uintptr(fd), "secret "+key)
+		secretsFiles[key] = f
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, info.Size())
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(b), nil
+}
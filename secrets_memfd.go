@@ -0,0 +1,29 @@
+//go:build linux && (amd64 || arm64)
+
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const mfdCloexec = 1
+
+// secretFile returns an anonymous in-memory file holding value.
+func secretFile(key, value string) (*os.File, error) {
+	name, err := syscall.BytePtrFromString("plugin-secret-" + key)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(name)), mfdCloexec, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("memfd_create", errno)
+	}
+	f := os.NewFile(fd, "secret "+key)
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
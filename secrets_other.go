@@ -0,0 +1,20 @@
+//go:build !(linux && (amd64 || arm64))
+
+package plugin
+
+import "os"
+
+// secretFile returns a temporary file holding value, unlinked so it
+// disappears once the last descriptor for it is closed.
+func secretFile(key, value string) (*os.File, error) {
+	f, err := os.CreateTemp("", "plugin-secret-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStartWithEnvSecrets(t *testing.T) {
+	path, args := helperProcess(t, "secret")
+	client, err := StartWithEnvSecrets(map[string]string{"TOKEN": "hunter2", "KEY": "s3cret"}, os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for key, want := range map[string]string{"TOKEN": "hunter2", "KEY": "s3cret"} {
+		for i := 0; i < 2; i++ {
+			var secret string
+			if err := client.Call("Test.Secret", key, &secret); err != nil || secret != want {
+				t.Errorf("expected secret %s to be %q, got %q, %v", key, want, secret, err)
+			}
+		}
+	}
+	var secret string
+	if err := client.Call("Test.Secret", "MISSING", &secret); err == nil {
+		t.Error("expected an error reading a secret that was not passed")
+	}
+
+	var environ string
+	if err := client.Call("Test.Environ", 0, &environ); err != nil {
+		t.Skip(err)
+	}
+	if strings.Contains(environ, "hunter2") || strings.Contains(environ, "s3cret") {
+		t.Error("expected secrets to stay out of the plugin's environment")
+	}
+}
+
+func TestWithSecrets(t *testing.T) {
+	path, args := helperProcess(t, "secret")
+	c, err := NewClient(path, WithArgs(args...), WithSecrets(map[string]string{"TOKEN": "hunter2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var secret string
+	if err := c.Call("Test.Secret", "TOKEN", &secret); err != nil || secret != "hunter2" {
+		t.Errorf("expected the secret sent at launch, got %q, %v", secret, err)
+	}
+	err = c.Call(initService+".Init", map[string]string{"TOKEN": "replaced"}, new(struct{}))
+	if err == nil || err.Error() != InitRejectedError.Error() {
+		t.Errorf("expected secrets sent after launch to be rejected, got %v", err)
+	}
+	if err := c.Call("Test.Secret", "TOKEN", &secret); err != nil || secret != "hunter2" {
+		t.Errorf("expected the secret sent at launch to stand, got %q, %v", secret, err)
+	}
+	var environ string
+	if err := c.Call("Test.Environ", 0, &environ); err == nil && strings.Contains(environ, "hunter2") {
+		t.Error("expected the secret to stay out of the plugin's environment")
+	}
+}
+
+func TestInitOnce(t *testing.T) {
+	d := newDispatcher()
+	i := &initializer{d: d}
+	if err := i.Init(map[string]string{"INIT_ONCE": "first"}, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Init(map[string]string{"INIT_ONCE": "second"}, new(struct{})); err != InitRejectedError {
+		t.Errorf("expected a second Init to be rejected, got %v", err)
+	}
+	if secret, _ := ReadSecret("INIT_ONCE"); secret != "first" {
+		t.Errorf("expected the first secret to stand, got %q", secret)
+	}
+
+	d = newDispatcher()
+	d.register("Test", newTestAPI())
+	s, mt, _ := d.lookup("Test.Echo")
+	var reply string
+	d.handler(s, mt)("hi", &reply)
+	if err := (&initializer{d: d}).Init(nil, new(struct{})); err != InitRejectedError {
+		t.Errorf("expected Init after a call to be rejected, got %v", err)
+	}
+
+	_, client := NewInProcess("Test", newTestAPI())
+	defer client.Close()
+	if err := client.Call(initService+".Init", map[string]string{}, new(struct{})); err == nil {
+		t.Error("expected plugins not created with New to have no init service")
+	}
+}
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+// The methods below stand in for those of rpc.Server, which Plugin used to
+// embed, for serving connections other than the plugin's own. Like Serve
+// they go through the plugin's dispatcher, so draining, middleware, rate
+// limits, method timeouts and Unregister apply to every connection.
+
+// ServeConn serves conn with the gob codec until the other end hangs up.
+func (p *Plugin) ServeConn(conn io.ReadWriteCloser) {
+	p.dispatch.serveCodec(newGobServerCodecSize(conn, p.conf.bufferSize, p.conf.messageLimit()))
+}
+
+// ServeRequest serves a single request read from codec, without closing
+// it.
+func (p *Plugin) ServeRequest(codec rpc.ServerCodec) error {
+	lc := &limitCodec{ServerCodec: noCloseCodec{codec}, left: 1}
+	err := p.dispatch.serveCodec(lc)
+	if err == io.EOF && lc.left < 0 {
+		return nil
+	}
+	return err
+}
+
+// noCloseCodec leaves closing the codec to whoever passed it in.
+type noCloseCodec struct {
+	rpc.ServerCodec
+}
+
+func (noCloseCodec) Close() error {
+	return nil
+}
+
+func (c noCloseCodec) Flush() error {
+	flush(c.ServerCodec)
+	return nil
+}
+
+// Accept serves each connection accepted on lis in its own goroutine,
+// until accepting fails.
+func (p *Plugin) Accept(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Printf("plugin %s accept: %s", p.label(), err)
+			return
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+const connected = "200 Connected to Go RPC"
+
+// ServeHTTP answers an HTTP CONNECT as rpc.Server does, serving the
+// hijacked connection, so rpc.DialHTTP clients can reach the plugin.
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Printf("plugin %s hijacking %s: %s", p.label(), req.RemoteAddr, err)
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
+	p.ServeConn(conn)
+}
+
+// HandleHTTP registers the plugin on http.DefaultServeMux at rpcPath, and a
+// plain text list of its methods at debugPath.
+func (p *Plugin) HandleHTTP(rpcPath, debugPath string) {
+	http.Handle(rpcPath, p)
+	http.HandleFunc(debugPath, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, name := range p.dispatch.methodNames() {
+			fmt.Fprintln(w, name)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+func TestAccept(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	var calls []string
+	p.Use(func(method string, h func(args, reply interface{}) error) func(args, reply interface{}) error {
+		return func(args, reply interface{}) error {
+			calls = append(calls, method)
+			return h(args, reply)
+		}
+	})
+	go p.Accept(l)
+
+	client, err := rpc.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "accepted", &reply); err != nil || reply != "accepted" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("expected middleware to run for accepted connections, got %q", calls)
+	}
+	p.Unregister("Test")
+	if err := client.Call("Test.Echo", "gone", &reply); err == nil || !strings.Contains(err.Error(), "can't find service") {
+		t.Errorf("expected Unregister to apply to accepted connections, got %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	_, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	client, err := rpc.DialHTTPPath("tcp", strings.TrimPrefix(srv.URL, "http://"), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "over http", &reply); err != nil || reply != "over http" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	p.BeginDrain()
+	if err := client.Call("Test.Echo", "late", &reply); err == nil || err.Error() != ShuttingDownError.Error() {
+		t.Errorf("expected draining to apply over HTTP, got %v", err)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a GET, got %s", resp.Status)
+	}
+}
+
+func TestServeRequest(t *testing.T) {
+	host, conn := net.Pipe()
+	p := NewFromConn("Test", conn, newTestAPI())
+	codec := newGobServerCodec(conn)
+	served := make(chan error, 1)
+	go func() { served <- p.ServeRequest(codec) }()
+	client := rpc.NewClient(host)
+	defer client.Close()
+	var reply string
+	if err := client.Call("Test.Echo", "once", &reply); err != nil || reply != "once" {
+		t.Fatalf("unexpected result %q, %v", reply, err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	go p.ServeRequest(codec)
+	if err := client.Call("Test.Echo", "twice", &reply); err != nil || reply != "twice" {
+		t.Errorf("expected the codec to stay open for the next request, got %q, %v", reply, err)
+	}
+}
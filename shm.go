@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// The segment holds one ring buffer per direction. Each ring's header holds
+// the total bytes written and read, advanced atomically by its only writer
+// and only reader, a closed flag set by either side on Close, and a
+// doorbell: a counter bumped on every change, which a side with nothing to
+// read or no room to write sleeps on, and a count of those sleeping, so
+// the other side only makes the system call to wake them when there are
+// any.
+const (
+	shmHeaderSize = 64
+	shmMinSize    = 4096
+	// shmSpins is how many times a side yields before sleeping on the
+	// doorbell, trading a little CPU for latency while the peer is
+	// expected to answer quickly.
+	shmSpins = 50
+	// shmWaitTimeout bounds each sleep, so a peer that dies without
+	// ringing is noticed.
+	shmWaitTimeout = 100 * time.Millisecond
+)
+
+var (
+	SharedMemoryNotSupportedError = Xrror("shared memory transport is not supported on this platform")
+	SharedMemorySizeError         = Xrror("shared memory segment of %d bytes is too small").Out
+)
+
+type sharedMemory struct {
+	name string
+	size int
+}
+
+// WithSharedMemory carries RPC traffic through a shared memory segment of
+// size bytes named like a shm_open name, rather than through the stdio
+// pipes, which saves the kernel round trip on every message. The host
+// creates the segment and removes it on Close; the plugin must be created
+// with the same option to attach to it.
+func WithSharedMemory(shmPath string, size int) Option {
+	return func(c *config) {
+		c.shm = &sharedMemory{shmPath, size}
+	}
+}
+
+type shmRing struct {
+	head, tail    *uint64
+	closed        *uint32
+	bell, waiters *uint32
+	data          []byte
+}
+
+func newShmRing(mem []byte, header int, data []byte) shmRing {
+	return shmRing{
+		head:    (*uint64)(unsafe.Pointer(&mem[header])),
+		tail:    (*uint64)(unsafe.Pointer(&mem[header+8])),
+		closed:  (*uint32)(unsafe.Pointer(&mem[header+16])),
+		bell:    (*uint32)(unsafe.Pointer(&mem[header+20])),
+		waiters: (*uint32)(unsafe.Pointer(&mem[header+24])),
+		data:    data,
+	}
+}
+
+// ring wakes whoever sleeps on r's doorbell.
+func (r shmRing) ring() {
+	atomic.AddUint32(r.bell, 1)
+	if atomic.LoadUint32(r.waiters) != 0 {
+		futexWake(r.bell)
+	}
+}
+
+// wait sleeps until r's doorbell moves on from seq, read before checking
+// the ring, or shmWaitTimeout passes.
+func (r shmRing) wait(seq uint32) {
+	atomic.AddUint32(r.waiters, 1)
+	futexWait(r.bell, seq, shmWaitTimeout)
+	atomic.AddUint32(r.waiters, ^uint32(0))
+}
+
+func (r shmRing) write(b []byte) int {
+	head, tail := atomic.LoadUint64(r.head), atomic.LoadUint64(r.tail)
+	size := uint64(len(r.data))
+	n := size - (head - tail)
+	if n > uint64(len(b)) {
+		n = uint64(len(b))
+	}
+	k := copy(r.data[head%size:], b[:n])
+	copy(r.data, b[k:n])
+	atomic.StoreUint64(r.head, head+n)
+	if n > 0 {
+		r.ring()
+	}
+	return int(n)
+}
+
+func (r shmRing) read(b []byte) int {
+	head, tail := atomic.LoadUint64(r.head), atomic.LoadUint64(r.tail)
+	size := uint64(len(r.data))
+	n := head - tail
+	if n > uint64(len(b)) {
+		n = uint64(len(b))
+	}
+	k := copy(b[:n], r.data[tail%size:])
+	copy(b[k:n], r.data)
+	atomic.StoreUint64(r.tail, tail+n)
+	if n > 0 {
+		r.ring()
+	}
+	return int(n)
+}
+
+func (r shmRing) close() {
+	atomic.StoreUint32(r.closed, 1)
+	r.ring()
+}
+
+func (r shmRing) isClosed() bool {
+	return atomic.LoadUint32(r.closed) != 0
+}
+
+// shmConn is one side's end of the segment. Reads and writes hold mu for
+// reading while they touch the segment, including while asleep on a
+// doorbell, so Close, which sets closing and rings both doorbells first,
+// only unmaps it once they are done.
+type shmConn struct {
+	rd, wr  shmRing
+	unmap   func() error
+	closing int32
+	mu      sync.RWMutex
+}
+
+func newShmConn(mem []byte, host bool, unmap func() error) *shmConn {
+	half := (len(mem) - 2*shmHeaderSize) / 2
+	data := mem[2*shmHeaderSize:]
+	toPlugin := newShmRing(mem, 0, data[:half])
+	toHost := newShmRing(mem, shmHeaderSize, data[half:2*half])
+	if host {
+		return &shmConn{rd: toHost, wr: toPlugin, unmap: unmap}
+	}
+	return &shmConn{rd: toPlugin, wr: toHost, unmap: unmap}
+}
+
+func (c *shmConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for spins := 0; ; spins++ {
+		seq := atomic.LoadUint32(c.rd.bell)
+		if atomic.LoadInt32(&c.closing) != 0 {
+			return 0, io.ErrClosedPipe
+		}
+		eof := c.rd.isClosed()
+		if n := c.rd.read(b); n > 0 {
+			return n, nil
+		}
+		if eof {
+			return 0, io.EOF
+		}
+		if spins < shmSpins {
+			runtime.Gosched()
+		} else {
+			c.rd.wait(seq)
+		}
+	}
+}
+
+func (c *shmConn) Write(b []byte) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	written := 0
+	for spins := 0; written < len(b); spins++ {
+		seq := atomic.LoadUint32(c.wr.bell)
+		if atomic.LoadInt32(&c.closing) != 0 || c.wr.isClosed() {
+			return written, io.ErrClosedPipe
+		}
+		if n := c.wr.write(b[written:]); n > 0 {
+			written += n
+			spins = -1
+		} else if spins < shmSpins {
+			runtime.Gosched()
+		} else {
+			c.wr.wait(seq)
+		}
+	}
+	return written, nil
+}
+
+// Close marks both rings closed, so the peer reads EOF once it has drained
+// what was written, and unmaps the segment once local reads and writes,
+// woken by the rings' doorbells, have returned.
+func (c *shmConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		return nil
+	}
+	c.rd.close()
+	c.wr.close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.unmap()
+}
+
+// closeOnEOF closes conn once r, the peer's end of a stdio pipe, reports
+// EOF, so a peer that exits without closing the segment is noticed.
+func closeOnEOF(r io.Reader, conn io.Closer) {
+	io.Copy(io.Discard, r)
+	conn.Close()
+}
+
+func (s *sharedMemory) open(host bool) (*shmConn, error) {
+	if s.size < shmMinSize {
+		return nil, SharedMemorySizeError(s.size)
+	}
+	return mapSharedMemory(shmFile(s.name), s.size, host)
+}
+
+// attach returns the host's transport over the segment for the process
+// behind pipe, which closes the pipe and removes the segment on Close.
+func (s *sharedMemory) attach(conn *shmConn, pipe *ioPipe) io.ReadWriteCloser {
+	go closeOnEOF(pipe.ReadCloser, conn)
+	return readWriteCloser{conn, conn, closerFunc(func() error {
+		conn.Close()
+		err := pipe.Close()
+		os.Remove(shmFile(s.name))
+		return err
+	})}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,25 @@
+package plugin
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	futexWaitOp = 0
+	futexWakeOp = 1
+)
+
+// futexWait sleeps until addr, in memory shared with another process, is
+// woken by futexWake, no longer holds val, or timeout passes.
+func futexWait(addr *uint32, val uint32, timeout time.Duration) {
+	ts := syscall.NsecToTimespec(int64(timeout))
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitOp,
+		uintptr(val), uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+func futexWake(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakeOp,
+		1<<31-1, 0, 0, 0)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Without futexes a side waiting on a doorbell polls it every 50µs
+// instead.
+func futexWait(addr *uint32, val uint32, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadUint32(addr) == val && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+func futexWake(addr *uint32) {}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// shmFile returns the file shm_open would use for name: under /dev/shm on
+// Linux and the temporary directory elsewhere.
+func shmFile(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if runtime.GOOS == "linux" {
+		return filepath.Join("/dev/shm", name)
+	}
+	return filepath.Join(os.TempDir(), name)
+}
+
+func mapSharedMemory(path string, size int, create bool) (*shmConn, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if create {
+		if err := f.Truncate(int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return newShmConn(mem, create, func() error { return syscall.Munmap(mem) }), nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package plugin
+
+func shmFile(name string) string {
+	return name
+}
+
+func mapSharedMemory(path string, size int, create bool) (*shmConn, error) {
+	return nil, SharedMemoryNotSupportedError
+}
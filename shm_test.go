@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func shmPair(t testing.TB, name string) (host, plugin *shmConn) {
+	t.Helper()
+	shm := &sharedMemory{name, 1 << 16}
+	host, err := shm.open(true)
+	if err == SharedMemoryNotSupportedError {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(shmFile(name)) })
+	if plugin, err = shm.open(false); err != nil {
+		t.Fatal(err)
+	}
+	return host, plugin
+}
+
+func TestSharedMemoryConn(t *testing.T) {
+	host, conn := shmPair(t, fmt.Sprintf("plugin-test-%d", os.Getpid()))
+	p := newPlugin("Test", "", conn, newTestAPI(), nil)
+	go p.Serve()
+	client := rpc.NewClient(host)
+	defer client.Close()
+
+	big := string(make([]byte, 1<<17))
+	for _, args := range []string{"small", big} {
+		var reply string
+		if err := client.Call("Test.Echo", args, &reply); err != nil || reply != args {
+			t.Errorf("unexpected reply of %d bytes, %v", len(reply), err)
+		}
+	}
+}
+
+func TestSharedMemoryClient(t *testing.T) {
+	name := fmt.Sprintf("plugin-helper-%d", os.Getpid())
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip(SharedMemoryNotSupportedError)
+	}
+	path, args := helperProcess(t, "shm")
+	c, err := NewClient(path, WithArgs(append(args, name)...), WithSharedMemory(name, 1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "shm", &reply); err != nil || reply != "shm" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	c.Close()
+	if _, err := os.Stat(shmFile(name)); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the segment, got %v", err)
+	}
+}
+
+func TestSharedMemoryCloseWakesReader(t *testing.T) {
+	host, conn := shmPair(t, fmt.Sprintf("plugin-close-%d", os.Getpid()))
+	defer conn.Close()
+	read := make(chan error, 1)
+	go func() {
+		_, err := host.Read(make([]byte, 1))
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	host.Close()
+	if err := <-read; err != io.ErrClosedPipe {
+		t.Errorf("expected ErrClosedPipe, got %v", err)
+	}
+	if d := time.Since(start); d >= shmWaitTimeout {
+		t.Errorf("expected Close to wake the reader, took %v", d)
+	}
+}
+
+func benchmarkEcho(b *testing.B, client *rpc.Client) {
+	var reply string
+	for i := 0; i < b.N; i++ {
+		if err := client.Call("Test.Echo", "ping", &reply); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSharedMemoryEcho(b *testing.B) {
+	host, conn := shmPair(b, fmt.Sprintf("plugin-bench-%d", os.Getpid()))
+	go newPlugin("Test", "", conn, newTestAPI(), nil).Serve()
+	client := rpc.NewClient(host)
+	defer client.Close()
+	benchmarkEcho(b, client)
+}
+
+func BenchmarkPipeEcho(b *testing.B) {
+	hostR, pluginW, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	pluginR, hostW, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	go newPlugin("Test", "", rwc(pluginR, pluginW), newTestAPI(), nil).Serve()
+	client := rpc.NewClient(rwc(hostR, hostW))
+	defer client.Close()
+	benchmarkEcho(b, client)
+}
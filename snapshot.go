@@ -0,0 +1,75 @@
+package plugin
+
+import "net/rpc"
+
+// snapshotService is the reserved service through which hosts snapshot
+// and restore a plugin's state.
+const snapshotService = "__Snapshot"
+
+var NotSnapshottableError = Xrror("plugin API does not implement Snapshottable")
+
+// Snapshottable is implemented by plugin APIs whose state can be
+// checkpointed and restored, for live migration or debugging.
+type Snapshottable interface {
+	Snapshot() ([]byte, error)
+	Restore([]byte) error
+}
+
+// Snapshot returns the serialized state of the plugin's API, the service
+// registered under the plugin's service name, if it implements
+// Snapshottable.
+func (p *Plugin) Snapshot() ([]byte, error) {
+	s, ok := p.dispatch.receiver(p.service).(Snapshottable)
+	if !ok {
+		return nil, NotSnapshottableError
+	}
+	return s.Snapshot()
+}
+
+// Restore restores the plugin's API to the state serialized in data.
+func (p *Plugin) Restore(data []byte) error {
+	s, ok := p.dispatch.receiver(p.service).(Snapshottable)
+	if !ok {
+		return NotSnapshottableError
+	}
+	return s.Restore(data)
+}
+
+// SnapshotRemote returns the serialized state of the plugin client talks
+// to, which must serve a Snapshottable API.
+func SnapshotRemote(client *rpc.Client) ([]byte, error) {
+	var data []byte
+	err := client.Call(snapshotService+".Snapshot", 0, &data)
+	return data, err
+}
+
+// RestoreRemote restores the plugin client talks to from data returned by
+// SnapshotRemote.
+func RestoreRemote(client *rpc.Client, data []byte) error {
+	return client.Call(snapshotService+".Restore", data, new(struct{}))
+}
+
+// snapshotter serves Plugin.Snapshot and Plugin.Restore over RPC.
+type snapshotter struct {
+	p *Plugin
+}
+
+func (s snapshotter) Snapshot(args int, reply *[]byte) error {
+	data, err := s.p.Snapshot()
+	*reply = data
+	return err
+}
+
+func (s snapshotter) Restore(data []byte, reply *struct{}) error {
+	return s.p.Restore(data)
+}
+
+func (d *dispatcher) receiver(name string) interface{} {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s, ok := d.services[name]
+	if !ok {
+		return nil
+	}
+	return s.rcvr.Interface()
+}
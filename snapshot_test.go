@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type counterAPI struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *counterAPI) Add(args int, reply *int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += args
+	*reply = c.n
+	return nil
+}
+
+func (c *counterAPI) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return []byte(strconv.Itoa(c.n)), nil
+}
+
+func (c *counterAPI) Restore(data []byte) error {
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n = n
+	return nil
+}
+
+func TestSnapshotRemote(t *testing.T) {
+	_, from := NewInProcess("Counter", &counterAPI{})
+	defer from.Close()
+	var n int
+	from.Call("Counter.Add", 5, &n)
+	data, err := SnapshotRemote(from)
+	if err != nil || string(data) != "5" {
+		t.Fatalf("unexpected snapshot %q, %v", data, err)
+	}
+
+	p, to := NewInProcess("Counter", &counterAPI{})
+	defer to.Close()
+	if err := RestoreRemote(to, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Call("Counter.Add", 1, &n); err != nil || n != 6 {
+		t.Errorf("expected the restored count to carry on, got %d, %v", n, err)
+	}
+	if data, err := p.Snapshot(); err != nil || string(data) != "6" {
+		t.Errorf("unexpected local snapshot %q, %v", data, err)
+	}
+
+	_, plain := pipePlugin(t, newTestAPI())
+	if _, err := SnapshotRemote(plain); err == nil {
+		t.Error("expected snapshotting a plain API to fail")
+	}
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// SSHSession is the part of a golang.org/x/crypto/ssh Session used to run a
+// plugin on a remote host; *ssh.Session satisfies it. If the session also
+// has ssh.Session's Signal method, it is used to interrupt the plugin on
+// Close; otherwise the session is closed, which typically hangs up the
+// remote process.
+type SSHSession interface {
+	StdinPipe() (io.WriteCloser, error)
+	StdoutPipe() (io.Reader, error)
+	Start(cmd string) error
+	Wait() error
+	Close() error
+}
+
+// SSHCommander runs the plugin at Path on the remote end of Session.
+type SSHCommander struct {
+	Session SSHSession
+	Path    string
+	Args    []string
+}
+
+func (s *SSHCommander) StdinPipe() (io.WriteCloser, error) {
+	return s.Session.StdinPipe()
+}
+
+func (s *SSHCommander) StdoutPipe() (io.ReadCloser, error) {
+	r, err := s.Session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(r), nil
+}
+
+func (s *SSHCommander) Start() (osProcess, error) {
+	words := append([]string{s.Path}, s.Args...)
+	for i, w := range words {
+		words[i] = shellQuote(w)
+	}
+	if err := s.Session.Start(strings.Join(words, " ")); err != nil {
+		return nil, err
+	}
+	return sshProcess{s.Session}, nil
+}
+
+// DialSSHPlugin starts the plugin at path on the remote end of session, a
+// new session on an established SSH connection, and returns a client for it.
+func DialSSHPlugin(session SSHSession, path string, args ...string) (*rpc.Client, error) {
+	pipe, err := start(&SSHCommander{session, path, args})
+	if err != nil {
+		return nil, err
+	}
+	return newClient(pipe, defaultMaxMessageSize), nil
+}
+
+type sshProcess struct {
+	session SSHSession
+}
+
+// Wait has no process state to report for a remote process.
+func (p sshProcess) Wait() (*os.ProcessState, error) {
+	return nil, p.session.Wait()
+}
+
+func (p sshProcess) Kill() error {
+	return p.session.Close()
+}
+
+var sshSignals = map[os.Signal]string{os.Interrupt: "INT", os.Kill: "KILL"}
+
+// Signal sends sig through the session's Signal method, found by reflection
+// so that the ssh package is not a dependency.
+func (p sshProcess) Signal(sig os.Signal) error {
+	name, ok := sshSignals[sig]
+	m := reflect.ValueOf(p.session).MethodByName("Signal")
+	if !ok || !m.IsValid() || m.Type().NumIn() != 1 || m.Type().In(0).Kind() != reflect.String {
+		return p.session.Close()
+	}
+	out := m.Call([]reflect.Value{reflect.ValueOf(name).Convert(m.Type().In(0))})
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		return err
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"io"
+	"testing"
+)
+
+type sshSignal string
+
+// fakeSession serves the test API in-process in place of a remote command.
+type fakeSession struct {
+	inR, outR *io.PipeReader
+	inW, outW *io.PipeWriter
+	cmd       string
+	signals   []sshSignal
+	done      chan struct{}
+}
+
+func (f *fakeSession) StdinPipe() (io.WriteCloser, error) {
+	f.inR, f.inW = io.Pipe()
+	return f.inW, nil
+}
+
+func (f *fakeSession) StdoutPipe() (io.Reader, error) {
+	f.outR, f.outW = io.Pipe()
+	return f.outR, nil
+}
+
+func (f *fakeSession) Start(cmd string) error {
+	f.cmd = cmd
+	f.done = make(chan struct{})
+	go func() {
+		newPlugin("Test", "", rwc(f.inR, f.outW), newTestAPI(), nil).Serve()
+		close(f.done)
+	}()
+	return nil
+}
+
+func (f *fakeSession) Wait() error {
+	<-f.done
+	return nil
+}
+
+func (f *fakeSession) Close() error {
+	return nil
+}
+
+func (f *fakeSession) Signal(sig sshSignal) error {
+	f.signals = append(f.signals, sig)
+	return nil
+}
+
+func TestDialSSHPlugin(t *testing.T) {
+	session := &fakeSession{}
+	client, err := DialSSHPlugin(session, "/opt/plugins/echo", "it's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `'/opt/plugins/echo' 'it'\''s'`; session.cmd != want {
+		t.Errorf("expected remote command %s, got %s", want, session.cmd)
+	}
+	var reply string
+	if err := client.Call("Test.Echo", "remote", &reply); err != nil || reply != "remote" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.signals) != 1 || session.signals[0] != "INT" {
+		t.Errorf("expected the session to be sent INT, got %v", session.signals)
+	}
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/rpc"
+	"os"
+	"sync"
+)
+
+// Stdout returns the writer plugin code should print to. The process's
+// standard output carries RPC traffic, so printing to os.Stdout, with
+// fmt.Print and friends or otherwise, corrupts the protocol; this is the
+// supported way out. Output written here is buffered a line at a time and
+// reaches the host through the plugin's standard error, or through the
+// reader StartWithStdoutCapture returned if the plugin was started with it.
+// A write blocks while the host is not keeping up.
+func (p *Plugin) Stdout() io.Writer {
+	return p.stdout
+}
+
+// lineWriter buffers what is written to it, writing it out to w whenever a
+// line is complete or the buffer fills.
+type lineWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newLineWriter(w io.Writer) *lineWriter {
+	return &lineWriter{w: bufio.NewWriter(w)}
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, err := l.w.Write(b)
+	if err == nil && bytes.IndexByte(b, '\n') >= 0 {
+		err = l.w.Flush()
+	}
+	return n, err
+}
+
+func (l *lineWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Flush()
+}
+
+// stdoutCaptureEnv tells a plugin started by StartWithStdoutCapture to frame
+// its standard output.
+const stdoutCaptureEnv = "PLUGIN_STDOUT_FRAMED"
+
+// Streams of a plugin's standard output when it is captured.
+const (
+	stdoutRPCStream    byte = 0x01
+	stdoutOutputStream byte = 0x02
+)
+
+// StartWithStdoutCapture is Start for plugins that print progress or other
+// output meant for the host alongside their RPC replies. Both travel over
+// the plugin's standard output in frames led by a stream byte, 0x01 for RPC
+// and 0x02 for output, and are split apart again on the host; the returned
+// reader yields what the plugin wrote to Plugin.Stdout. Output is buffered
+// until read, so a slow reader never holds up calls, but only up to 16MiB:
+// past that the reader fails with StreamOverflowError and later output is
+// dropped. Callers that want output should keep reading it.
+func StartWithStdoutCapture(output io.Writer, path string, args ...string) (*rpc.Client, io.Reader, error) {
+	cmd := makeCommand(output, path, args)
+	if e, ok := cmd.(execCmd); ok {
+		e.Env = append(os.Environ(), stdoutCaptureEnv+"=1")
+	}
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	framer := NewFramer(pipe, stdoutRPCStream, stdoutOutputStream)
+	rpcConn := framerConn{framer.Stream(stdoutRPCStream), framer}
+	return newClient(rpcConn, defaultMaxMessageSize), framer.Stream(stdoutOutputStream), nil
+}
+
+// framerConn is a stream whose Close closes the whole connection.
+type framerConn struct {
+	io.ReadWriteCloser
+	framer *Framer
+}
+
+func (c framerConn) Close() error {
+	return c.framer.Close()
+}
+
+var StdoutCaptureTransportError = Xrror("standard output can only be captured while RPC runs over standard input and output")
+
+// captureStdout splits the plugin's connection into RPC and output streams
+// when the host asked for it with StartWithStdoutCapture. It must run once
+// the transport is settled, as the split only works on stdio: with RPC
+// moved to shared memory or a named pipe it fails with
+// StdoutCaptureTransportError.
+func (p *Plugin) captureStdout() error {
+	if os.Getenv(stdoutCaptureEnv) == "" {
+		return nil
+	}
+	if _, ok := p.ReadWriteCloser.(rwCloser); !ok {
+		return StdoutCaptureTransportError
+	}
+	framer := NewFramer(p.ReadWriteCloser, stdoutRPCStream, stdoutOutputStream)
+	p.ReadWriteCloser = framer.Stream(stdoutRPCStream)
+	p.stdout = newLineWriter(framer.Stream(stdoutOutputStream))
+	return nil
+}
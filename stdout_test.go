@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStartWithStdoutCapture(t *testing.T) {
+	path, args := helperProcess(t, "progress")
+	client, out, err := StartWithStdoutCapture(os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var reply int
+			if err := client.Call("Test.Work", 3, &reply); err != nil || reply != 3 {
+				t.Errorf("unexpected result %d, %v", reply, err)
+			}
+		}()
+	}
+	counts := map[string]int{}
+	for i := 0; i < 12; i++ {
+		counts[<-lines]++
+	}
+	wg.Wait()
+	for step := 1; step <= 3; step++ {
+		if line := fmt.Sprintf("step %d of 3", step); counts[line] != 4 {
+			t.Errorf("expected %q 4 times, got %v", line, counts)
+		}
+	}
+}
+
+func TestStdoutCaptureOverflow(t *testing.T) {
+	path, args := helperProcess(t, "progress")
+	client, out, err := StartWithStdoutCapture(os.Stderr, path, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	if err := client.Call("Test.Flood", maxStreamBuffer+1, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Test.Work", 3, &reply); err != nil || reply != 3 {
+		t.Errorf("expected calls to go on past unread output, got %d, %v", reply, err)
+	}
+	if _, err := io.ReadAll(out); err == nil || !strings.Contains(err.Error(), "unread bytes") {
+		t.Errorf("expected StreamOverflowError, got %v", err)
+	}
+}
+
+func TestPluginStdout(t *testing.T) {
+	stdout := os.Stdout
+	New("Test", "", newTestAPI())
+	if os.Stdout != stdout {
+		t.Error("expected New to leave os.Stdout alone")
+	}
+
+	var out bytes.Buffer
+	w := newLineWriter(&out)
+	fmt.Fprint(w, "partial")
+	if out.Len() != 0 {
+		t.Errorf("expected a partial line to be buffered, got %q", out.String())
+	}
+	fmt.Fprintln(w, " line")
+	if out.String() != "partial line\n" {
+		t.Errorf("expected the line once complete, got %q", out.String())
+	}
+}
+
+func TestStdoutCaptureTransport(t *testing.T) {
+	t.Setenv(stdoutCaptureEnv, "1")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	p := newPlugin("Test", "", rwc(r, w), newTestAPI(), nil)
+	if err := p.captureStdout(); err != nil {
+		t.Fatalf("unexpected error capturing over stdio: %v", err)
+	}
+	if _, ok := p.ReadWriteCloser.(rwCloser); ok {
+		t.Error("expected the stdio connection to be framed")
+	}
+
+	_, conn := net.Pipe()
+	defer conn.Close()
+	p = newPlugin("Test", "", conn, newTestAPI(), nil)
+	if err := p.captureStdout(); err != StdoutCaptureTransportError {
+		t.Errorf("expected StdoutCaptureTransportError off stdio, got %v", err)
+	}
+}
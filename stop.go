@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"time"
+)
+
+// shutdownService is the reserved service through which Client.Stop tells
+// a plugin it is about to be stopped.
+const shutdownService = "__Shutdown"
+
+type stopTimeoutSetter interface {
+	setStopTimeout(time.Duration)
+}
+
+// StopGracefully closes client, then pipe, giving the plugin process up to
+// timeout to exit after its pipes close before it is killed. Closing pipe
+// after client is safe when client was built on it.
+func StopGracefully(client *rpc.Client, pipe io.Closer, timeout time.Duration) error {
+	if s, ok := pipe.(stopTimeoutSetter); ok {
+		s.setStopTimeout(timeout)
+	}
+	err := client.Close()
+	if err == rpc.ErrShutdown {
+		err = nil
+	}
+	if closeErr := pipe.Close(); closeErr != nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Stop is the one way to stop a plugin that does everything in the right
+// order. It asks the plugin to refuse new calls, waits for the calls in
+// progress to return, then closes the plugin's stdin so it sees the host
+// hang up and exits. If ctx has a deadline, Stop returns by it: of the
+// time left once the calls are done, the plugin has half to exit on its
+// own and a quarter after an interrupt before it is killed, leaving the
+// last quarter to reap it. A done ctx skips straight to the interrupt.
+// Finally the connection is closed and the client with it, so later calls
+// fail with ClientClosedError. Stop may be called more than once and
+// concurrently; each call returns the first's result, and Stop after
+// Close returns nil.
+func (c *Client) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() { c.stopErr = c.stop(ctx) })
+	return c.stopErr
+}
+
+func (c *Client) stop(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	client := c.rpc
+	drained := c.retire(client)
+	c.mu.Unlock()
+
+	if client != nil {
+		// The request is written before Go returns, so the plugin sees it
+		// ahead of the hang-up. Nothing waits on the reply: plugins from
+		// before the shutdown service fail the call, which only means they
+		// keep accepting calls until the hang-up, and a busy plugin's late
+		// reply must not eat into the time to stop it.
+		client.Go(shutdownService+".Shutdown", 0, new(struct{}), make(chan *rpc.Call, 1))
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pipe != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			c.pipe.stopBy = deadline
+		}
+		if ctx.Err() != nil {
+			c.pipe.skipDrain = true
+			c.pipe.stopBy = time.Now()
+		}
+	}
+	err := c.shutdown()
+	if err == rpc.ErrShutdown {
+		err = nil
+	}
+	return err
+}
+
+// shutdowner serves the plugin's side of Client.Stop.
+type shutdowner struct {
+	p *Plugin
+}
+
+func (s shutdowner) Shutdown(args int, reply *struct{}) error {
+	s.p.BeginDrain()
+	return nil
+}
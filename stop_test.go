@@ -0,0 +1,302 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/rpc"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type stubbornProc struct {
+	*fakeProc
+}
+
+func (stubbornProc) Signal(os.Signal) error {
+	return nil
+}
+
+func stopPipe(proc osProcess) (*rpc.Client, *ioPipe) {
+	r, w := io.Pipe()
+	pipe := &ioPipe{ReadCloser: r, WriteCloser: w, proc: proc, exit: new(exitStatus)}
+	return rpc.NewClient(pipe), pipe
+}
+
+func TestStopGracefully(t *testing.T) {
+	client, pipe := stopPipe(newFakeProc())
+	if err := StopGracefully(client, pipe, time.Second); err != nil {
+		t.Errorf("unexpected error stopping cooperative process: %s", err)
+	}
+}
+
+func TestStopGracefullyKills(t *testing.T) {
+	proc := stubbornProc{newFakeProc()}
+	client, pipe := stopPipe(proc)
+	start := time.Now()
+	if err := StopGracefully(client, pipe, 20*time.Millisecond); err != ProcStopTimeoutError {
+		t.Errorf("expected ProcStopTimeoutError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected kill after the given timeout, took %s", elapsed)
+	}
+	select {
+	case <-proc.done:
+	default:
+		t.Error("expected process to be killed")
+	}
+}
+
+type orderCloser struct {
+	name  string
+	order *[]string
+	io.Reader
+	io.Writer
+}
+
+func (o orderCloser) Close() error {
+	*o.order = append(*o.order, o.name)
+	return nil
+}
+
+func TestPipeCloseOrder(t *testing.T) {
+	var order []string
+	r, w := io.Pipe()
+	pipe := &ioPipe{
+		ReadCloser:  orderCloser{name: "read", order: &order, Reader: r},
+		WriteCloser: orderCloser{name: "write", order: &order, Writer: io.Discard},
+		proc:        newFakeProc(),
+		exit:        new(exitStatus),
+	}
+	// The plugin writes its last reply after its stdin is closed.
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("last reply"))
+		w.Close()
+		wrote <- err
+	}()
+	if err := pipe.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-wrote:
+		if err != nil {
+			t.Errorf("expected the last reply to be drained, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Close to drain the plugin's output")
+	}
+	if len(order) != 2 || order[0] != "write" || order[1] != "read" {
+		t.Errorf("expected the write side closed before the read side, got %v", order)
+	}
+}
+
+// lingeringProc ignores interrupts and takes a while to be reaped once
+// killed.
+type lingeringProc struct {
+	*fakeProc
+	waited chan struct{}
+}
+
+func (lingeringProc) Signal(os.Signal) error {
+	return nil
+}
+
+func (p lingeringProc) Wait() (*os.ProcessState, error) {
+	p.fakeProc.Wait()
+	close(p.waited)
+	return nil, nil
+}
+
+func (p lingeringProc) Kill() error {
+	time.AfterFunc(20*time.Millisecond, func() { p.fakeProc.Kill() })
+	return nil
+}
+
+func TestStopProcReapsKilled(t *testing.T) {
+	proc := lingeringProc{newFakeProc(), make(chan struct{})}
+	if err := stopProc(proc, new(exitStatus), 10*time.Millisecond, killWaitTimeout, nil); err != ProcStopTimeoutError {
+		t.Fatalf("expected ProcStopTimeoutError, got %v", err)
+	}
+	select {
+	case <-proc.waited:
+	default:
+		t.Error("expected the killed process to be waited for before returning")
+	}
+}
+
+func TestPreKillHook(t *testing.T) {
+	proc := stubbornProc{newFakeProc()}
+	signalled := make(chan os.Signal, 1)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	hook := newConfig([]Option{WithPreKillHook(20*time.Millisecond, func(p ProcessSignaler) {
+		if p != osProcess(proc) {
+			t.Error("expected the hook to get the plugin process")
+		}
+		p.Signal(os.Kill)
+		signalled <- os.Kill
+		<-stuck // A stuck hook must not hold up the kill.
+	})}).preKill
+
+	done := make(chan error, 1)
+	go func() { done <- stopProc(proc, new(exitStatus), 10*time.Millisecond, killWaitTimeout, hook) }()
+	select {
+	case err := <-done:
+		if err != ProcStopTimeoutError {
+			t.Errorf("expected ProcStopTimeoutError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the hook's timeout to bound it")
+	}
+	select {
+	case <-signalled:
+	default:
+		t.Error("expected the hook to run before the kill")
+	}
+}
+
+func TestClientStop(t *testing.T) {
+	api := newTestAPI()
+	withFakePlugin(t, api)
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var slow string
+	inflight := make(chan error, 1)
+	go func() { inflight <- c.Call("Test.Slow", "slow", &slow) }()
+	<-api.started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Stop(ctx) }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("expected Stop to wait for the call in progress, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(api.release)
+	if err := <-inflight; err != nil || slow != "slow" {
+		t.Errorf("expected the in-progress call to complete, got %q, %v", slow, err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error stopping: %v", err)
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Errorf("expected Stop to be idempotent, got %v", err)
+	}
+	var reply string
+	if err := c.Call("Test.Echo", "late", &reply); err != ClientClosedError {
+		t.Errorf("expected ClientClosedError after Stop, got %v", err)
+	}
+}
+
+func TestClientStopKills(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("needs a plugin that ignores interrupts")
+	}
+	path, args := helperProcess(t, "stubborn")
+	c, err := NewClient(path, WithArgs(args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.Stop(ctx); err != ProcStopTimeoutError {
+		t.Errorf("expected ProcStopTimeoutError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the plugin to be killed by the deadline, took %s", elapsed)
+	}
+	if err := c.Stop(ctx); err != ProcStopTimeoutError {
+		t.Errorf("expected a repeated Stop to return the first result, got %v", err)
+	}
+}
+
+func TestShutdownRefusesCalls(t *testing.T) {
+	_, client := pipePlugin(t, newTestAPI())
+	if err := client.Call(shutdownService+".Shutdown", 0, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Test.Echo", "late", &reply); err == nil || err.Error() != ShuttingDownError.Error() {
+		t.Errorf("expected %q after the shutdown call, got %v", ShuttingDownError, err)
+	}
+}
+
+// deafProc ignores interrupts and only exits when killed.
+type deafProc struct {
+	*fakeProc
+}
+
+func (deafProc) Signal(os.Signal) error {
+	return nil
+}
+
+func TestPipeCloseSharesDeadline(t *testing.T) {
+	// The plugin keeps its stdout open and writes nothing more.
+	r, w := io.Pipe()
+	defer w.Close()
+	_, in := io.Pipe()
+	pipe := &ioPipe{
+		ReadCloser:  r,
+		WriteCloser: in,
+		proc:        deafProc{newFakeProc()},
+		exit:        new(exitStatus),
+		stopTimeout: 200 * time.Millisecond,
+	}
+	// The RPC client's reader, blocked until Close begins.
+	read := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, err := pipe.Read(make([]byte, 1))
+		read <- err
+	}()
+	start := time.Now()
+	if err := pipe.Close(); err != ProcStopTimeoutError {
+		t.Errorf("expected ProcStopTimeoutError, got %v", err)
+	}
+	if d := time.Since(start); d > 350*time.Millisecond {
+		t.Errorf("expected the drain and the wait to share the stop timeout, took %v", d)
+	}
+	if err := <-read; err != io.ErrClosedPipe {
+		t.Errorf("expected reads to fail once Close began, got %v", err)
+	}
+}
+
+// hungProc never exits, not even when killed.
+type hungProc struct {
+	*fakeProc
+}
+
+func (hungProc) Signal(os.Signal) error {
+	return nil
+}
+
+func (hungProc) Kill() error {
+	return nil
+}
+
+func TestPipeCloseStopDeadline(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	_, in := io.Pipe()
+	pipe := &ioPipe{
+		ReadCloser:  r,
+		WriteCloser: in,
+		proc:        hungProc{newFakeProc()},
+		exit:        new(exitStatus),
+		stopBy:      time.Now().Add(200 * time.Millisecond),
+	}
+	start := time.Now()
+	if err := pipe.Close(); err != ProcStopTimeoutError {
+		t.Errorf("expected ProcStopTimeoutError, got %v", err)
+	}
+	if d := time.Since(start); d > 350*time.Millisecond {
+		t.Errorf("expected every step of the close to fit the stop deadline, took %v", d)
+	}
+}
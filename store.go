@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// PluginStore persists the manifests of a host's plugins and their last
+// known process IDs to a JSON file, so a restarted host can bring its
+// plugins back with Restore.
+type PluginStore struct {
+	path string
+
+	mu      sync.Mutex
+	records []storeRecord
+
+	// verify and attach are replaced in tests.
+	verify func(pid int, path string) bool
+	attach func(pid int) (io.ReadWriteCloser, error)
+}
+
+type storeRecord struct {
+	Manifest PluginManifest
+	PID      int
+}
+
+// NewPluginStore returns a store backed by the file at path, loading any
+// records already saved there.
+func NewPluginStore(path string) (*PluginStore, error) {
+	s := &PluginStore{path: path, verify: procExeMatches, attach: attachProcStdio}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save records m, replacing any record with the same Name, along with the
+// process ID of its running plugin. Restore only re-attaches to the
+// process if it was launched with m.InstanceID, which must then be set.
+func (s *PluginStore) Save(m PluginManifest, pid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(storeRecord{m, pid})
+	return s.write()
+}
+
+// Remove forgets the plugin called name.
+func (s *PluginStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.records {
+		if r.Manifest.Name == name {
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			break
+		}
+	}
+	return s.write()
+}
+
+// Restore returns a client for every saved plugin. A plugin whose recorded
+// process is still running the same executable, checked through
+// /proc/<pid>/exe, is re-attached through that process's /proc stdio,
+// which only works while it still holds the pipes it was started with, on
+// Linux, and kept only if it answers with the InstanceID in its manifest,
+// so a recycled process ID running the same binary is not taken for it.
+// Any other plugin is launched afresh, under a new random InstanceID if
+// its manifest has none, and its new process ID saved.
+func (s *PluginStore) Restore() ([]*rpc.Client, error) {
+	s.mu.Lock()
+	records := append([]storeRecord(nil), s.records...)
+	s.mu.Unlock()
+
+	var clients []*rpc.Client
+	fail := func(err error) ([]*rpc.Client, error) {
+		for _, c := range clients {
+			c.Close()
+		}
+		return nil, err
+	}
+	for i, r := range records {
+		if client := s.reattach(r); client != nil {
+			clients = append(clients, client)
+			continue
+		}
+		if r.Manifest.InstanceID == "" {
+			id, err := newInstanceToken()
+			if err != nil {
+				return fail(err)
+			}
+			records[i].Manifest.InstanceID = id
+		}
+		client, pid, err := records[i].Manifest.launch(s.path)
+		if err != nil {
+			return fail(err)
+		}
+		records[i].PID = pid
+		clients = append(clients, client)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		for j := range s.records {
+			if s.records[j].Manifest.Name == r.Manifest.Name {
+				s.records[j] = r
+			}
+		}
+	}
+	if err := s.write(); err != nil {
+		return fail(err)
+	}
+	return clients, nil
+}
+
+// reattach returns a client for r's process if it is still running and
+// answers with r's instance ID, or nil.
+func (s *PluginStore) reattach(r storeRecord) *rpc.Client {
+	if r.PID <= 0 || r.Manifest.InstanceID == "" || !s.verify(r.PID, r.Manifest.Path) {
+		return nil
+	}
+	conn, err := s.attach(r.PID)
+	if err != nil {
+		return nil
+	}
+	client := r.Manifest.client(conn)
+	if id, err := remoteInstanceID(client, handshakeTimeout); err != nil || id != r.Manifest.InstanceID {
+		client.Close()
+		return nil
+	}
+	return client
+}
+
+func newInstanceToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *PluginStore) put(r storeRecord) {
+	for i := range s.records {
+		if s.records[i].Manifest.Name == r.Manifest.Name {
+			s.records[i] = r
+			return
+		}
+	}
+	s.records = append(s.records, r)
+}
+
+// write replaces the store's file with the records, writing them to a
+// temporary file beside it first so that a crash mid-write leaves the old
+// file intact rather than a truncated one.
+func (s *PluginStore) write() error {
+	data, err := json.MarshalIndent(s.records, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (m *PluginManifest) client(conn io.ReadWriteCloser) *rpc.Client {
+	limit := (&config{maxMessageSize: m.MaxMessageSize}).messageLimit()
+	if m.Codec == "json" {
+		return newJSONClient(conn, limit)
+	}
+	return newClient(conn, limit)
+}
+
+func (m *PluginManifest) launch(source string) (*rpc.Client, int, error) {
+	opts, err := m.options(source)
+	if err != nil {
+		return nil, 0, err
+	}
+	conf := newConfig(opts)
+	cmd, err := conf.command(m.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	pipe, err := start(cmd)
+	if err != nil {
+		return nil, 0, err
+	}
+	conn, _, err := hostHandshake(pipe, conf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return m.client(conn), pipe.pid, nil
+}
+
+func procExeMatches(pid int, path string) bool {
+	exe, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return false
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return exe == path
+}
+
+func attachProcStdio(pid int) (io.ReadWriteCloser, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+	in, err := os.OpenFile(filepath.Join(dir, "0"), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.Open(filepath.Join(dir, "1"))
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return rwc(out, in), nil
+}
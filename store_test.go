@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPluginStoreRestore(t *testing.T) {
+	path, args := helperProcess(t, "echo")
+	file := filepath.Join(t.TempDir(), "plugins.json")
+	store, err := NewPluginStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Save(PluginManifest{Name: "running", Path: path, Args: args, InstanceID: "a"}, 4242)
+	store.Save(PluginManifest{Name: "gone", Path: path, Args: args}, 4343)
+	store.Save(PluginManifest{Name: "recycled", Path: path, Args: args, InstanceID: "c"}, 4444)
+
+	// Reopen the store as a restarted host would, with 4242 still running
+	// and 4444 reused by another instance of the same binary.
+	store, err = NewPluginStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attached []int
+	store.verify = func(pid int, _ string) bool { return pid != 4343 }
+	store.attach = func(pid int) (io.ReadWriteCloser, error) {
+		attached = append(attached, pid)
+		host, conn := net.Pipe()
+		id := "a"
+		if pid == 4444 {
+			id = "other"
+		}
+		go NewFromConn("Test", conn, newTestAPI(), WithInstanceID(id)).Serve()
+		return host, nil
+	}
+	clients, err := store.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		var reply string
+		if err := c.Call("Test.Echo", "restored", &reply); err != nil || reply != "restored" {
+			t.Errorf("unexpected result %q, %v", reply, err)
+		}
+		c.Close()
+	}
+	if len(clients) != 3 || len(attached) != 2 || attached[0] != 4242 || attached[1] != 4444 {
+		t.Errorf("expected 3 clients after attaching to 4242 and 4444, got %d after %v", len(clients), attached)
+	}
+	if r := store.records[0]; r.PID != 4242 {
+		t.Errorf("expected the re-attached plugin's pid to be kept, got %d", r.PID)
+	}
+	for _, r := range store.records[1:] {
+		if r.PID == 4343 || r.PID == 4444 || r.PID == 0 || r.Manifest.InstanceID == "" {
+			t.Errorf("expected %s to be relaunched and saved with an instance ID, got %+v", r.Manifest.Name, r)
+		}
+	}
+	if id := store.records[2].Manifest.InstanceID; id != "c" {
+		t.Errorf("expected the manifest's own instance ID to be kept, got %q", id)
+	}
+}
+
+func TestProcExeMatches(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs /proc")
+	}
+	if !procExeMatches(os.Getpid(), os.Args[0]) {
+		t.Error("expected the test binary to match its own process")
+	}
+	if procExeMatches(os.Getpid(), "/bin/sh") {
+		t.Error("expected another executable not to match")
+	}
+}
+
+func TestPluginStoreWrite(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "plugins.json")
+	store, err := NewPluginStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid, name := range []string{"first", "second"} {
+		if err := store.Save(PluginManifest{Name: name}, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err = NewPluginStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.records) != 2 || store.records[1].PID != 1 {
+		t.Errorf("expected both records back, got %+v", store.records)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the store's file to be left, got %d entries", len(entries))
+	}
+	if info, err := os.Stat(file); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0600) {
+		t.Errorf("expected the store's file to be private, got %v, %v", info, err)
+	}
+}
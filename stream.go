@@ -0,0 +1,334 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Framer streams carrying streaming calls, named like the RPC streams for
+// the side that makes the calls.
+const (
+	hostStreamStream   byte = 2
+	pluginStreamStream byte = 3
+)
+
+var (
+	StreamNotFoundError  = Xrror("no stream handler registered for %s").Out
+	StreamCancelledError = Xrror("stream cancelled by the caller")
+	StreamHandlerError   = Xrror("stream handler for %s must be func(args T, send func(interface{}) error) error").Out
+	typeOfSend           = reflect.TypeOf((func(interface{}) error)(nil))
+)
+
+// Result is one value produced by a streaming call, or the error that ended
+// it.
+type Result struct {
+	data []byte
+	Err  error
+}
+
+// Decode decodes the result into v, which must be a pointer to a type the
+// sender's value can be gob-decoded into.
+func (r Result) Decode(v interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return gob.NewDecoder(bytes.NewReader(r.data)).Decode(v)
+}
+
+type streamMsg struct {
+	ID     uint64
+	Method string
+	Data   []byte
+	Err    string
+	Done   bool
+	Cancel bool
+}
+
+func encodeValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// streamer makes streaming calls on one Framer stream and answers the other
+// end's on another.
+type streamer struct {
+	mu       sync.Mutex
+	handlers map[string]reflect.Value
+	calls    map[uint64]*streamCall
+	serving  map[uint64]chan struct{}
+	seq      uint64
+	err      error
+
+	callEnc, serveEnc *gob.Encoder
+	callMu, serveMu   sync.Mutex
+}
+
+// streamCall is a streaming call in progress. Its results channel is
+// closed once, by finish, under mu, so a cancelled call and the reader
+// never race to close it.
+type streamCall struct {
+	ctx      context.Context
+	results  chan Result
+	finished chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// deliver passes r on to the caller unless the call has been finished or
+// its context is done.
+func (c *streamCall) deliver(r Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		select {
+		case c.results <- r:
+		case <-c.ctx.Done():
+		}
+	}
+}
+
+// finish closes the call's results, after r if it is not nil, which is
+// delivered if there is room for it even once the context is done.
+func (c *streamCall) finish(r *Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if r != nil {
+		select {
+		case c.results <- *r:
+		default:
+			select {
+			case c.results <- *r:
+			case <-c.ctx.Done():
+			}
+		}
+	}
+	c.closed = true
+	close(c.results)
+	close(c.finished)
+}
+
+func newStreamer(call, serve io.ReadWriter) *streamer {
+	s := &streamer{
+		handlers: make(map[string]reflect.Value),
+		calls:    make(map[uint64]*streamCall),
+		serving:  make(map[uint64]chan struct{}),
+		callEnc:  gob.NewEncoder(call),
+		serveEnc: gob.NewEncoder(serve),
+	}
+	go s.receive(gob.NewDecoder(call))
+	go s.serve(gob.NewDecoder(serve))
+	return s
+}
+
+func (s *streamer) register(method string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.In(1) != typeOfSend || t.NumOut() != 1 || t.Out(0) != typeOfError {
+		return StreamHandlerError(method)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = v
+	return nil
+}
+
+func (s *streamer) stream(ctx context.Context, method string, args interface{}) (<-chan Result, error) {
+	data, err := encodeValue(args)
+	if err != nil {
+		return nil, err
+	}
+	call := &streamCall{ctx: ctx, results: make(chan Result, 16), finished: make(chan struct{})}
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.seq++
+	id := s.seq
+	s.calls[id] = call
+	s.mu.Unlock()
+
+	if err := s.callMsg(streamMsg{ID: id, Method: method, Data: data}); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				if s.remove(id) != nil {
+					s.callMsg(streamMsg{ID: id, Cancel: true})
+					call.finish(&Result{Err: ctx.Err()})
+				}
+			case <-call.finished:
+			}
+		}()
+	}
+	return call.results, nil
+}
+
+func (s *streamer) callMsg(msg streamMsg) error {
+	s.callMu.Lock()
+	defer s.callMu.Unlock()
+	return s.callEnc.Encode(msg)
+}
+
+// remove takes call id out of the open calls, returning it if it was
+// there.
+func (s *streamer) remove(id uint64) *streamCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call := s.calls[id]
+	delete(s.calls, id)
+	return call
+}
+
+// receive routes results to the calls they belong to, and once the
+// connection fails, ends every call still open and fails new ones. Results
+// are delivered outside s.mu, so a caller slow to drain its channel holds
+// up only this reader.
+func (s *streamer) receive(dec *gob.Decoder) {
+	var err error
+	for {
+		var msg streamMsg
+		if err = dec.Decode(&msg); err != nil {
+			break
+		}
+		s.mu.Lock()
+		call := s.calls[msg.ID]
+		if msg.Done {
+			delete(s.calls, msg.ID)
+		}
+		s.mu.Unlock()
+		switch {
+		case call == nil:
+		case msg.Done && msg.Err != "":
+			call.finish(&Result{Err: errors.New(msg.Err)})
+		case msg.Done:
+			call.finish(nil)
+		default:
+			call.deliver(Result{data: msg.Data})
+		}
+	}
+	if err == io.EOF {
+		err = PluginClosedError
+	}
+	s.mu.Lock()
+	s.err = err
+	calls := s.calls
+	s.calls = make(map[uint64]*streamCall)
+	s.mu.Unlock()
+	for _, call := range calls {
+		call.finish(&Result{Err: err})
+	}
+}
+
+// serve runs a handler for each call the other end makes, and tells those
+// it cancels to stop.
+func (s *streamer) serve(dec *gob.Decoder) {
+	for {
+		var msg streamMsg
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		s.mu.Lock()
+		if msg.Cancel {
+			if cancel := s.serving[msg.ID]; cancel != nil {
+				close(cancel)
+				delete(s.serving, msg.ID)
+			}
+			s.mu.Unlock()
+			continue
+		}
+		cancel := make(chan struct{})
+		s.serving[msg.ID] = cancel
+		s.mu.Unlock()
+		go s.handle(msg, cancel)
+	}
+}
+
+func (s *streamer) handle(msg streamMsg, cancel chan struct{}) {
+	s.mu.Lock()
+	fn, ok := s.handlers[msg.Method]
+	s.mu.Unlock()
+	var err error = StreamNotFoundError(msg.Method)
+	if ok {
+		err = s.call(fn, msg, cancel)
+	}
+	s.mu.Lock()
+	delete(s.serving, msg.ID)
+	s.mu.Unlock()
+	done := streamMsg{ID: msg.ID, Done: true}
+	if err != nil {
+		done.Err = err.Error()
+	}
+	s.send(done)
+}
+
+func (s *streamer) call(fn reflect.Value, msg streamMsg, cancel chan struct{}) error {
+	args := reflect.New(fn.Type().In(0))
+	if err := gob.NewDecoder(bytes.NewReader(msg.Data)).DecodeValue(args); err != nil {
+		return err
+	}
+	send := func(v interface{}) error {
+		select {
+		case <-cancel:
+			return StreamCancelledError
+		default:
+		}
+		data, err := encodeValue(v)
+		if err != nil {
+			return err
+		}
+		return s.send(streamMsg{ID: msg.ID, Data: data})
+	}
+	out := fn.Call([]reflect.Value{args.Elem(), reflect.ValueOf(send)})
+	err, _ := out[0].Interface().(error)
+	return err
+}
+
+func (s *streamer) send(msg streamMsg) error {
+	s.serveMu.Lock()
+	defer s.serveMu.Unlock()
+	return s.serveEnc.Encode(msg)
+}
+
+// RegisterStream registers fn to answer streaming calls to method made
+// with Stream from the other end. fn has the form
+//
+//	func(args T, send func(interface{}) error) error
+//
+// and calls send once for each result, in order, before returning. The
+// error it returns, if any, is the last result the caller receives. Once
+// the caller cancels the stream, send fails with StreamCancelledError and
+// fn should return.
+func (b *BiDiPlugin) RegisterStream(method string, fn interface{}) error {
+	return b.streams.register(method, fn)
+}
+
+// Stream calls method on the other end, which must have registered it with
+// RegisterStream, and returns a channel of its results in the order they
+// were sent. The channel is closed after the last result; a failed call
+// ends with a Result carrying the error. Results for every stream on the
+// connection arrive through one reader, so a caller that stops early must
+// keep draining the channel, or use StreamCtx and cancel.
+func (b *BiDiPlugin) Stream(method string, args interface{}) (<-chan Result, error) {
+	return b.streams.stream(context.Background(), method, args)
+}
+
+// StreamCtx is Stream ending the call when ctx is done: the other end's
+// handler is told to stop, and the channel is closed after a last Result
+// carrying ctx.Err(), if there is room for it.
+func (b *BiDiPlugin) StreamCtx(ctx context.Context, method string, args interface{}) (<-chan Result, error) {
+	return b.streams.stream(ctx, method, args)
+}
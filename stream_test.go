@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func bidiPair(t *testing.T) (host, plugin *BiDiPlugin) {
+	t.Helper()
+	hostConn, pluginConn := net.Pipe()
+	plugin = newBiDi(pluginConn, false, newPlugin("Test", "", nil, newTestAPI(), nil))
+	host = newBiDi(hostConn, true, newPlugin("Host", "", nil, &hostAPI{}, nil))
+	t.Cleanup(func() { host.Close() })
+	return host, plugin
+}
+
+func TestStream(t *testing.T) {
+	host, plugin := bidiPair(t)
+	err := plugin.RegisterStream("Search", func(prefix string, send func(interface{}) error) error {
+		for _, word := range []string{"go", "gob", "rpc", "goroutine"} {
+			if strings.HasPrefix(word, prefix) {
+				if err := send(word); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := host.Stream("Search", "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var words []string
+	for r := range results {
+		var word string
+		if err := r.Decode(&word); err != nil {
+			t.Fatal(err)
+		}
+		words = append(words, word)
+	}
+	if strings.Join(words, ",") != "go,gob,goroutine" {
+		t.Errorf("expected results in order, got %v", words)
+	}
+
+	results, err = host.Stream("Missing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := <-results; r.Err == nil || !strings.Contains(r.Err.Error(), "no stream handler") {
+		t.Errorf("expected an error for an unregistered stream, got %v", r.Err)
+	}
+	if err := plugin.RegisterStream("Bad", func(string) error { return nil }); err == nil {
+		t.Error("expected an error registering a handler of the wrong form")
+	}
+}
+
+func TestStreamCtxCancel(t *testing.T) {
+	host, plugin := bidiPair(t)
+	stopped := make(chan error, 1)
+	plugin.RegisterStream("Count", func(from int, send func(interface{}) error) error {
+		for i := from; ; i++ {
+			if err := send(i); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := host.StreamCtx(ctx, "Count", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first int
+	if err := (<-results).Decode(&first); err != nil || first != 1 {
+		t.Fatalf("unexpected first result %d, %v", first, err)
+	}
+	cancel()
+	for range results {
+	}
+	select {
+	case err := <-stopped:
+		if err != StreamCancelledError {
+			t.Errorf("expected the handler's send to fail with StreamCancelledError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected cancelling to stop the handler")
+	}
+}
+
+func TestStreamAfterClose(t *testing.T) {
+	host, plugin := bidiPair(t)
+	host.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := plugin.Stream("Search", "go")
+		if err == PluginClosedError {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected Stream to fail once the connection is gone, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
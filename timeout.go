@@ -0,0 +1,28 @@
+package plugin
+
+import (
+	"context"
+	"net/rpc"
+	"time"
+)
+
+var CallTimeoutError = Xrror("plugin call to %s timed out after %s").Out
+
+// CallTimeout calls serviceMethod through client, failing with
+// CallTimeoutError if no reply has come within timeout. Only the one call
+// is abandoned: client stays usable, reply is left untouched and the late
+// reply, if any, is discarded.
+func CallTimeout(client *rpc.Client, serviceMethod string, args, reply interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	call := goCall(ctx, client, serviceMethod, args, reply)
+	if call == nil {
+		return CallTimeoutError(serviceMethod, timeout)
+	}
+	return call.Error
+}
+
+// CallTimeout calls the other end as CallTimeout does.
+func (b *BiDiPlugin) CallTimeout(serviceMethod string, args, reply interface{}, timeout time.Duration) error {
+	return CallTimeout(b.Client, serviceMethod, args, reply, timeout)
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCallTimeout(t *testing.T) {
+	before := runtime.NumGoroutine()
+	api := newTestAPI()
+	_, client := NewInProcess("Test", api)
+
+	var reply string
+	if err := CallTimeout(client, "Test.Echo", "fast", &reply, time.Second); err != nil || reply != "fast" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	reply = ""
+	if err := CallTimeout(client, "Test.Slow", "slow", &reply, 20*time.Millisecond); err == nil {
+		t.Error("expected the slow call to time out")
+	}
+	var echo string
+	if err := CallTimeout(client, "Test.Echo", "after", &echo, time.Second); err != nil || echo != "after" {
+		t.Errorf("expected the client to outlive the timeout, got %q, %v", echo, err)
+	}
+	close(api.release)
+	time.Sleep(10 * time.Millisecond)
+	if reply != "" {
+		t.Errorf("expected reply untouched after the timeout, got %q", reply)
+	}
+	client.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("expected no leaked goroutines, %d before and %d after:\n%s", before, n, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	api := newTestAPI()
+	defer close(api.release)
+	withFakePlugin(t, api)
+	c, err := NewClient("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var reply string
+	if err := c.CallTimeout("Test.Slow", "slow", &reply, 20*time.Millisecond); err == nil || err.Error() != CallTimeoutError("Test.Slow", 20*time.Millisecond).Error() {
+		t.Errorf("expected CallTimeoutError, got %v", err)
+	}
+	if err := c.CallTimeout("Test.Echo", "fast", &reply, time.Second); err != nil || reply != "fast" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+}
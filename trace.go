@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+)
+
+// TraceParentKey is the CallContext metadata key under which CallTraced
+// propagates trace context, as a W3C Trace Context traceparent value:
+// "00-<32 hex digit trace ID>-<16 hex digit parent span ID>-<2 hex digit
+// flags>", for example
+//
+//	00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+const TraceParentKey = "traceparent"
+
+// WithTraceInjector has CallTraced propagate the span current in a call's
+// context: inject returns its traceparent, or "" if there is none. With
+// OpenTelemetry, for example, inject formats
+// trace.SpanContextFromContext(ctx).
+func WithTraceInjector(inject func(ctx context.Context) string) Option {
+	return func(c *config) {
+		c.traceInject = inject
+	}
+}
+
+// WithSpanStarter has the plugin start a span for each call that arrives
+// with trace context: start gets the caller's traceparent and the method
+// called, and returns a function that ends the span with the call's error.
+func WithSpanStarter(start func(traceparent, serviceMethod string) (end func(error))) Option {
+	return func(c *config) {
+		c.startSpan = start
+	}
+}
+
+// CallTraced is CallCtx carrying the trace context of ctx, as returned by
+// the WithTraceInjector hook, to the plugin, whose WithSpanStarter hook can
+// start a child span from it. Methods can also read it with ContextValue
+// under TraceParentKey. Without a span in ctx it is CallCtx.
+func (c *Client) CallTraced(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	var traceparent string
+	if c.conf.traceInject != nil {
+		traceparent = c.conf.traceInject(ctx)
+	}
+	if !validTraceParent(traceparent) {
+		return c.CallCtx(ctx, serviceMethod, args, reply)
+	}
+	return c.sendContext(ctx, map[string]string{TraceParentKey: traceparent}, serviceMethod, args, reply)
+}
+
+// validTraceParent reports whether s is a version 00 traceparent naming a
+// trace and span, as others must be ignored.
+func validTraceParent(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return false
+	}
+	for i, n := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != n || strings.Trim(parts[i], "0123456789abcdef") != "" {
+			return false
+		}
+	}
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+)
+
+type traceKey struct{}
+
+func TestCallTraced(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var spans []string
+	ended := error(ShuttingDownError)
+	withFakePlugin(t, tracedAPI{}, WithSpanStarter(func(traceparent, method string) func(error) {
+		spans = append(spans, traceparent+" "+method)
+		return func(err error) { ended = err }
+	}))
+	c, err := NewClient("test", WithTraceInjector(func(ctx context.Context) string {
+		traceparent, _ := ctx.Value(traceKey{}).(string)
+		return traceparent
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.WithValue(context.Background(), traceKey{}, parent)
+	var reply string
+	if err := c.CallTraced(ctx, "Test.Parent", &tracedArgs{Name: "tracer"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if want := parent + ": hello tracer"; reply != want {
+		t.Errorf("expected the method to see the traceparent, got %q", reply)
+	}
+	if len(spans) != 1 || spans[0] != parent+" Test.Parent" || ended != nil {
+		t.Errorf("expected one child span ended without error, got %q, %v", spans, ended)
+	}
+
+	if err := c.CallTraced(context.Background(), "Test.Echo", "untraced", &reply); err != nil || reply != "untraced" {
+		t.Errorf("unexpected result %q, %v", reply, err)
+	}
+	if len(spans) != 1 {
+		t.Errorf("expected no span without trace context, got %q", spans)
+	}
+}
+
+func TestValidTraceParent(t *testing.T) {
+	for s, want := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01": false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":    false,
+		"": false,
+	} {
+		if got := validTraceParent(s); got != want {
+			t.Errorf("validTraceParent(%q) = %t, want %t", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+type exitStatus struct {
+	mu    sync.Mutex
+	state *os.ProcessState
+}
+
+func (e *exitStatus) set(state *os.ProcessState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state = state
+}
+
+func (e *exitStatus) get() *os.ProcessState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+// Usage is the resources consumed by a plugin process over its lifetime.
+// MaxRSS is in bytes and is only reported on Unix systems that provide
+// getrusage; elsewhere Usage returns UsageNotSupportedError.
+type Usage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+	MaxRSS     int64
+}
+
+var (
+	ProcessRunningError    = Xrror("plugin process has not exited")
+	UsageNotSupportedError = Xrror("process resource usage is not supported on this platform")
+)
+
+// Usage reports the resource usage of the most recently exited plugin
+// process, and ProcessRunningError while the current one is still running.
+func (c *Client) Usage() (Usage, error) {
+	c.mu.Lock()
+	exit := c.exit
+	c.mu.Unlock()
+	if exit == nil || exit.get() == nil {
+		return Usage{}, ProcessRunningError
+	}
+	return processUsage(exit.get())
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package plugin
+
+import "os"
+
+func processUsage(state *os.ProcessState) (Usage, error) {
+	return Usage{}, UsageNotSupportedError
+}
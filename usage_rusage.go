@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package plugin
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func processUsage(state *os.ProcessState) (Usage, error) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return Usage{}, UsageNotSupportedError
+	}
+	rss := int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		rss *= 1024
+	}
+	return Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     rss,
+	}, nil
+}
@@ -13,9 +13,21 @@ func (x *xrror) Error() string {
 	return fmt.Sprintf("%s", fmt.Sprintf(x.base, x.vals...))
 }
 
+// Out returns a new error formatting vals into x. An error among vals is
+// wrapped as its cause.
 func (x *xrror) Out(vals ...interface{}) *xrror {
-	x.vals = vals
-	return x
+	return &xrror{base: x.base, vals: vals}
+}
+
+// Unwrap returns the first error x was formatted with, so errors.Is and
+// errors.As see through x to its cause.
+func (x *xrror) Unwrap() error {
+	for _, v := range x.vals {
+		if err, ok := v.(error); ok {
+			return err
+		}
+	}
+	return nil
 }
 
 func Xrror(base string) *xrror {